@@ -3,292 +3,730 @@ package elasticsearch
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	_ "github.com/elastic/go-elasticsearch/v8/esapi"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
 	"github.com/elastic/go-elasticsearch/v8/esutil"
-	_ "github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/core/search"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/expandwildcard"
 	"github.com/go-yaaf/yaaf-common/logger"
-	"strings"
-	"sync/atomic"
-	"time"
 
 	. "github.com/go-yaaf/yaaf-common/entity"
 )
 
+// region Bulk result definitions --------------------------------------------------------------------------------------
+
+const (
+	defaultItemRetryBackoff = 100 * time.Millisecond // Default initial backoff of bulk item retries
+	maxItemRetryBackoff     = 10 * time.Second       // Maximum backoff of bulk item retries
+	resolveIndicesBatchSize = 1000                   // Maximum number of IDs in a single index resolution query
+)
+
+// BulkItemFailure holds the details of a single failed bulk item
+type BulkItemFailure struct {
+	ID     string // The document ID
+	Index  string // The index name
+	Action string // The bulk action (index, create, update, delete)
+	Status int    // HTTP status of the item (0 if the item was not sent)
+	Type   string // Elasticsearch error type (e.g. version_conflict_engine_exception)
+	Reason string // Elasticsearch error reason
+	Err    error  // The item error (classified as *StoreError when returned by elasticsearch)
+}
+
+// BulkResult holds the result of a bulk operation
+type BulkResult struct {
+	Succeeded             int64             // Number of successful items
+	Failed                int64             // Number of items failed by elasticsearch (after retries)
+	Retried               int64             // Number of item retries (an item retried twice is counted twice)
+	Recovered             int64             // Number of items succeeded after retry
+	DeadLettered          int64             // Number of failed items written to the dead letter sink
+	Failures              []BulkItemFailure // Details of the items failed by elasticsearch
+	SerializationFailures []BulkItemFailure // Details of the entities which could not be serialized (not sent)
+	NotFound              []string          // IDs of the documents to delete which were not found
+}
+
+// Err returns the error of the first failed item or nil if all the items succeeded
+func (r *BulkResult) Err() error {
+	if len(r.SerializationFailures) > 0 {
+		return r.SerializationFailures[0].Err
+	}
+	if len(r.Failures) > 0 {
+		return r.Failures[0].Err
+	}
+	return nil
+}
+
+// FailedIDs returns the IDs of all the failed items (including serialization failures)
+func (r *BulkResult) FailedIDs() []string {
+	ids := make([]string, 0, len(r.Failures)+len(r.SerializationFailures))
+	for _, f := range r.SerializationFailures {
+		ids = append(ids, f.ID)
+	}
+	for _, f := range r.Failures {
+		ids = append(ids, f.ID)
+	}
+	return ids
+}
+
+// Add serialization failure
+func (r *BulkResult) addSerializationFailure(id, index, action string, err error) {
+	r.SerializationFailures = append(r.SerializationFailures, BulkItemFailure{ID: id, Index: index, Action: action, Err: err})
+}
+
+// Add failure of document which does not exist in the index pattern (not sent)
+func (r *BulkResult) addMissingDocument(id, pattern, action string) {
+	r.Failed++
+	r.Failures = append(r.Failures, BulkItemFailure{
+		ID: id, Index: pattern, Action: action, Status: http.StatusNotFound, Type: "document_missing_exception",
+		Err: notFoundError("document: %s not found in index pattern: %s", id, pattern),
+	})
+}
+
+// Log summary of the failures
+func (r *BulkResult) logFailures(operation string) {
+	if count := int(r.Failed) + len(r.SerializationFailures); count > 0 {
+		logger.Error("%s: %d items failed, first error: %s", operation, count, r.Err())
+	}
+}
+
+// Create bulk item failure from the bulk indexer response item
+func newBulkItemFailure(item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) BulkItemFailure {
+	failure := BulkItemFailure{ID: item.DocumentID, Index: item.Index, Action: item.Action, Status: res.Status, Err: err}
+	if len(res.Index) > 0 {
+		failure.Index = res.Index
+	}
+	if err == nil {
+		failure.Type = res.Error.Type
+		failure.Reason = res.Error.Reason
+		failure.Err = bulkItemError(res)
+	}
+	return failure
+}
+
+// Check if the failed item can be retried: rejected by elasticsearch (429, 503) or not acknowledged (failed request)
+func (f BulkItemFailure) retriable() bool {
+	switch {
+	case f.Status == 0:
+		return f.Err != nil
+	case f.Status == http.StatusTooManyRequests, f.Status == http.StatusServiceUnavailable:
+		return true
+	default:
+		return f.Type == "es_rejected_execution_exception"
+	}
+}
+
+// Convert bulk item error to classified error
+func bulkItemError(res esutil.BulkIndexerResponseItem) error {
+	ee := types.NewElasticsearchError()
+	ee.Status = res.Status
+	ee.ErrorCause.Type = res.Error.Type
+	reason := res.Error.Reason
+	ee.ErrorCause.Reason = &reason
+	if len(res.Error.Cause.Type) > 0 {
+		causeReason := res.Error.Cause.Reason
+		ee.ErrorCause.CausedBy = &types.ErrorCause{Type: res.Error.Cause.Type, Reason: &causeReason}
+	}
+	if se, ok := ElasticError(ee).(*StoreError); ok {
+		if len(se.Index) == 0 {
+			se.Index = res.Index
+		}
+		return se
+	}
+	return ee
+}
+
+// endregion
+
 // region Bulk operations ----------------------------------------------------------------------------------------------
 
 // BulkInsert inserts multiple entities
 func (dbs *ElasticStore) BulkInsert(entities []Entity) (int64, error) {
-	if len(entities) == 0 {
-		return 0, nil
+	result, err := dbs.BulkInsertWithResult(entities)
+	result.logFailures("bulk insert")
+	return result.Succeeded, err
+}
+
+// BulkInsertWithResult inserts multiple entities and returns the detailed result
+func (dbs *ElasticStore) BulkInsertWithResult(entities []Entity, opts ...BulkOption) (*BulkResult, error) {
+	return dbs.bulkEntities(entities, "index", opts...)
+}
+
+// BulkUpdate updates multiple entities (partial documents), documents which do not exist are reported as failures
+func (dbs *ElasticStore) BulkUpdate(entities []Entity) (int64, error) {
+	result, err := dbs.BulkUpdateWithResult(entities)
+	result.logFailures("bulk update")
+	return result.Succeeded, err
+}
+
+// BulkUpdateWithResult updates multiple entities (partial documents) and returns the detailed result
+// Each document is updated in its current index, documents which do not exist are reported as failures (ErrNotFound)
+func (dbs *ElasticStore) BulkUpdateWithResult(entities []Entity, opts ...BulkOption) (*BulkResult, error) {
+	return dbs.bulkEntities(entities, "update", opts...)
+}
+
+// BulkUpsert update or insert multiple entities
+func (dbs *ElasticStore) BulkUpsert(entities []Entity) (int64, error) {
+	result, err := dbs.BulkUpsertWithResult(entities)
+	result.logFailures("bulk upsert")
+	return result.Succeeded, err
+}
+
+// BulkUpsertWithResult update (partial documents) or insert multiple entities and returns the detailed result
+// Existing documents are updated in their current index, new documents are created in the index of the entity time
+func (dbs *ElasticStore) BulkUpsertWithResult(entities []Entity, opts ...BulkOption) (*BulkResult, error) {
+	return dbs.bulkEntities(entities, "upsert", opts...)
+}
+
+// BulkDelete delete multiple entities by IDs
+func (dbs *ElasticStore) BulkDelete(factory EntityFactory, entityIDs []string, keys ...string) (int64, error) {
+	result, err := dbs.BulkDeleteWithResult(factory, entityIDs, keys...)
+	result.logFailures("bulk delete")
+	return result.Succeeded, err
+}
+
+// BulkDeleteWithResult delete multiple entities by IDs and returns the detailed result
+// Use BulkDeleteWithOptions to override the bulk settings
+func (dbs *ElasticStore) BulkDeleteWithResult(factory EntityFactory, entityIDs []string, keys ...string) (*BulkResult, error) {
+	return dbs.BulkDeleteWithOptions(factory, entityIDs, nil, keys...)
+}
+
+// BulkDeleteWithOptions delete multiple entities by IDs using the provided bulk settings and returns the detailed result
+// Each document is deleted from all the indices it exists in, result.Succeeded counts the deleted documents (not the
+// deleted copies), the IDs of documents which do not exist are reported in result.NotFound
+func (dbs *ElasticStore) BulkDeleteWithOptions(factory EntityFactory, entityIDs []string, opts []BulkOption, keys ...string) (*BulkResult, error) {
+
+	result := &BulkResult{}
+	if len(entityIDs) == 0 {
+		return result, nil
 	}
 
-	index := indexName(entities[0].TABLE(), entities[0].KEY())
+	table := factory().TABLE()
+	index := dbs.indexName(table, keys...)
 
-	// Get bulk indexer
-	bi, err := dbs.getBulkIndexer(index)
+	indices, err := dbs.documentIndices(table, entityIDs, keys...)
 	if err != nil {
-		return 0, err
+		return result, err
 	}
 
-	affected := uint64(0)
-
-	for _, ent := range entities {
-		data, er := Marshal(ent)
-		if er != nil {
-			continue
+	missing := make([]string, 0)
+	copies := make(map[string]int, len(entityIDs))
+	items := make([]bulkItem, 0, len(entityIDs))
+	for _, entId := range entityIDs {
+		if indices == nil {
+			items = append(items, bulkItem{index: index, action: "delete", id: entId})
+			copies[entId]++
+		} else if docIndices, ok := indices[entId]; !ok {
+			missing = append(missing, entId)
 		} else {
-			if err = bi.Add(context.Background(), esutil.BulkIndexerItem{
-				Index:      indexName(ent.TABLE(), ent.KEY()),
-				Action:     "index",
-				DocumentID: ent.ID(),
-				Body:       bytes.NewReader(data),
-
-				OnSuccess: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
-					atomic.AddUint64(&affected, 1)
-				},
-				// OnFailure is called for each failed operation
-				OnFailure: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
-					if err != nil {
-						logger.Error("OnFailure error: %s", err.Error())
-					} else {
-						logger.Error("OnFailure error: %s: %s", res.Error.Type, res.Error.Reason)
-					}
-				},
-			}); err != nil {
-				logger.Error("%s", err.Error())
+			for _, docIndex := range docIndices {
+				items = append(items, bulkItem{index: docIndex, action: "delete", id: entId})
+				copies[entId]++
 			}
 		}
 	}
 
-	if err = bi.Close(context.Background()); err != nil {
-		return int64(affected), err
+	// Count the deleted documents, not the deleted copies
+	result, err = dbs.executeBulk(index, items, result, dbs.bulkOptions(opts...))
+	result.Succeeded, result.NotFound = deletedDocuments(entityIDs, copies, result)
+	result.NotFound = append(missing, result.NotFound...)
+	return result, err
+}
+
+// Count the documents deleted by the bulk delete items: a document is deleted if any of its copies was deleted
+// Returns the number of deleted documents and the IDs of the documents whose copies were all not found
+func deletedDocuments(ids []string, copies map[string]int, result *BulkResult) (int64, []string) {
+	failed := make(map[string]int)
+	for _, f := range result.Failures {
+		failed[f.ID]++
+	}
+	notFound := make(map[string]int)
+	for _, id := range result.NotFound {
+		notFound[id]++
 	}
-	return int64(affected), nil
+
+	deleted := int64(0)
+	missing := make([]string, 0)
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] || copies[id] == 0 {
+			continue
+		}
+		seen[id] = true
+		if notFound[id] == copies[id] {
+			missing = append(missing, id)
+		} else if failed[id]+notFound[id] < copies[id] {
+			deleted++
+		}
+	}
+	return deleted, missing
 }
 
-// BulkUpdate updates multiple entities
-func (dbs *ElasticStore) BulkUpdate(entities []Entity) (int64, error) {
-	if len(entities) == 0 {
-		return 0, nil
+// BulkSetFields Update specific field of multiple entities in a single transaction (eliminates the need to fetch - change - update)
+// The field is the name of the field, values is a map of entityId -> field value
+func (dbs *ElasticStore) BulkSetFields(factory EntityFactory, field string, values map[string]any, keys ...string) (int64, error) {
+	result, err := dbs.BulkSetFieldsWithResult(factory, field, values, keys...)
+	result.logFailures("bulk set fields")
+	return result.Succeeded, err
+}
+
+// BulkSetFieldsWithResult Update specific field of multiple entities and returns the detailed result
+// The field is the name of the field, values is a map of entityId -> field value
+// Use BulkSetFieldsWithOptions to override the bulk settings
+func (dbs *ElasticStore) BulkSetFieldsWithResult(factory EntityFactory, field string, values map[string]any, keys ...string) (*BulkResult, error) {
+	return dbs.BulkSetFieldsWithOptions(factory, field, values, nil, keys...)
+}
+
+// BulkSetFieldsWithOptions Update specific field of multiple entities using the provided bulk settings and returns the detailed result
+func (dbs *ElasticStore) BulkSetFieldsWithOptions(factory EntityFactory, field string, values map[string]any, opts []BulkOption, keys ...string) (*BulkResult, error) {
+	fields := make(map[string]map[string]any, len(values))
+	for id, val := range values {
+		fields[id] = map[string]any{field: val}
 	}
+	return dbs.BulkSetFieldsMapWithOptions(factory, fields, opts, keys...)
+}
 
-	index := indexName(entities[0].TABLE(), entities[0].KEY())
+// BulkSetFieldsMap Update multiple fields of multiple entities in a single transaction
+// The fields is a map of entityId -> map of field name -> field value, the values are JSON encoded (nested objects and arrays are supported)
+func (dbs *ElasticStore) BulkSetFieldsMap(factory EntityFactory, fields map[string]map[string]any, keys ...string) (int64, error) {
+	result, err := dbs.BulkSetFieldsMapWithResult(factory, fields, keys...)
+	result.logFailures("bulk set fields")
+	return result.Succeeded, err
+}
 
-	// Get bulk indexer
-	bi, err := dbs.getBulkIndexer(index)
-	if err != nil {
-		return 0, err
+// BulkSetFieldsMapWithResult Update multiple fields of multiple entities and returns the detailed result
+// Use BulkSetFieldsMapWithOptions to override the bulk settings
+func (dbs *ElasticStore) BulkSetFieldsMapWithResult(factory EntityFactory, fields map[string]map[string]any, keys ...string) (*BulkResult, error) {
+	return dbs.BulkSetFieldsMapWithOptions(factory, fields, nil, keys...)
+}
+
+// BulkSetFieldsMapWithOptions Update multiple fields of multiple entities using the provided bulk settings and returns the detailed result
+// Each document is updated in its current index, documents which do not exist are reported as failures (ErrNotFound)
+func (dbs *ElasticStore) BulkSetFieldsMapWithOptions(factory EntityFactory, fields map[string]map[string]any, opts []BulkOption, keys ...string) (*BulkResult, error) {
+
+	result := &BulkResult{}
+	if len(fields) == 0 {
+		return result, nil
 	}
 
-	affected := uint64(0)
-	failed := uint64(0)
+	table := factory().TABLE()
+	index := dbs.indexName(table, keys...)
+	pattern := dbs.indexPatternFromTable(table, keys...)
 
-	successFunc := func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
-		atomic.AddUint64(&affected, 1)
+	ids := make([]string, 0, len(fields))
+	for id := range fields {
+		ids = append(ids, id)
 	}
+	sort.Strings(ids)
 
-	failureFunc := func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
-		atomic.AddUint64(&failed, 1)
-		if err != nil {
-			logger.Error("OnFailure error: %s", err.Error())
-		} else {
-			logger.Error("OnFailure error: %s: %s", res.Error.Type, res.Error.Reason)
-		}
+	indices, err := dbs.documentIndices(table, ids, keys...)
+	if err != nil {
+		return result, err
 	}
 
-	for _, ent := range entities {
-		data, er := Marshal(ent)
+	items := make([]bulkItem, 0, len(ids))
+	for _, id := range ids {
+		docIndex := index
+		if indices != nil {
+			if idx, ok := indices[id]; !ok {
+				result.addMissingDocument(id, pattern, "update")
+				continue
+			} else {
+				docIndex = idx[0]
+			}
+		}
+
+		data, er := json.Marshal(map[string]any{"doc": fields[id]})
 		if er != nil {
+			result.addSerializationFailure(id, docIndex, "update", er)
 			continue
-		} else {
-			if err = bi.Add(context.Background(), esutil.BulkIndexerItem{
-				Index:      indexName(ent.TABLE(), ent.KEY()),
-				Action:     "index",
-				DocumentID: ent.ID(),
-				Body:       bytes.NewReader(data),
-				OnSuccess:  successFunc,
-				OnFailure:  failureFunc,
-			}); err != nil {
-				logger.Error("%s", err.Error())
-			}
 		}
+		items = append(items, bulkItem{index: docIndex, action: "update", id: id, body: data, retryOnConflict: &dbs.cfg.retryOnConflict})
 	}
+	return dbs.executeBulk(index, items, result, dbs.bulkOptions(opts...))
+}
 
-	if err = bi.Close(context.Background()); err != nil {
-		return int64(affected), err
-	}
-	return int64(affected), nil
+// endregion
+
+// region Datastore bulk helper methods --------------------------------------------------------------------------------
+
+// bulkItem is a single operation of the bulk request
+type bulkItem struct {
+	index  string // The index name
+	action string // The bulk action (index, create, update, delete)
+	id     string // The document ID
+	body   []byte // The document body (nil for delete)
+
+	retryOnConflict *int // Number of retries on version conflict (update action only)
 }
 
-// BulkUpsert update or insert multiple entities
-func (dbs *ElasticStore) BulkUpsert(entities []Entity) (int64, error) {
+// Serialize entities and execute the bulk action on all of them: index, update (partial document) or upsert
+// For update and upsert the concrete index of existing documents is resolved when the table is time partitioned
+func (dbs *ElasticStore) bulkEntities(entities []Entity, action string, opts ...BulkOption) (*BulkResult, error) {
+
+	result := &BulkResult{}
 	if len(entities) == 0 {
-		return 0, nil
+		return result, nil
 	}
 
-	index := indexName(entities[0].TABLE(), entities[0].KEY())
+	index := dbs.indexName(entities[0].TABLE(), entities[0].KEY())
 
-	// Get bulk indexer
-	bi, err := dbs.getBulkIndexer(index)
-	if err != nil {
-		return 0, err
+	// Resolve the index of existing documents, upserted entities which provide their time are written to the index
+	// of their time (see Upsert)
+	resolve := make([]bool, len(entities))
+	unresolved := make([]Entity, 0, len(entities))
+	for i, ent := range entities {
+		if action == "index" || !dbs.requiresIndexResolution(ent.TABLE(), ent.KEY()) {
+			continue
+		}
+		if _, ok := dbs.entityTime(ent); ok && action == "upsert" {
+			continue
+		}
+		resolve[i] = true
+		unresolved = append(unresolved, ent)
 	}
 
-	affected := uint64(0)
-	failed := uint64(0)
-
-	successFunc := func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
-		atomic.AddUint64(&affected, 1)
+	indices, err := dbs.resolveEntityIndices(unresolved)
+	if err != nil {
+		return result, err
 	}
 
-	failureFunc := func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
-		atomic.AddUint64(&failed, 1)
+	items := make([]bulkItem, 0, len(entities))
+	for i, ent := range entities {
+		entIndex := dbs.entityIndexName(ent)
+
+		if resolve[i] {
+			pattern := dbs.indexPatternFromTable(ent.TABLE(), ent.KEY())
+			if idx, ok := indices[pattern][ent.ID()]; ok {
+				entIndex = idx[0]
+			} else if action == "update" {
+				result.addMissingDocument(ent.ID(), pattern, action)
+				continue
+			}
+		}
+
+		data, err := Marshal(ent)
 		if err != nil {
-			logger.Error("OnFailure error: %s", err.Error())
-		} else {
-			logger.Error("OnFailure error: %s: %s", res.Error.Type, res.Error.Reason)
+			result.addSerializationFailure(ent.ID(), entIndex, action, err)
+			continue
 		}
+
+		item := bulkItem{index: entIndex, action: action, id: ent.ID(), body: data}
+		switch action {
+		case "update":
+			item.body = []byte(fmt.Sprintf(`{"doc":%s}`, data))
+			item.retryOnConflict = &dbs.cfg.retryOnConflict
+		case "upsert":
+			item.action = "update"
+			item.body = []byte(fmt.Sprintf(`{"doc":%s,"doc_as_upsert":true}`, data))
+			item.retryOnConflict = &dbs.cfg.retryOnConflict
+		}
+		items = append(items, item)
 	}
+	return dbs.executeBulk(index, items, result, dbs.bulkOptions(opts...))
+}
 
+// Resolve the concrete index of the entities which require index resolution (see requiresIndexResolution)
+// Returns map of index pattern -> document ID -> index names, the latest first (see resolveIndices)
+func (dbs *ElasticStore) resolveEntityIndices(entities []Entity) (map[string]map[string][]string, error) {
+
+	ids := make(map[string][]string)
 	for _, ent := range entities {
-		data, er := Marshal(ent)
-		if er != nil {
-			continue
-		} else {
-			if err = bi.Add(context.Background(), esutil.BulkIndexerItem{
-				Index:      indexName(ent.TABLE(), ent.KEY()),
-				Action:     "index",
-				DocumentID: ent.ID(),
-				Body:       bytes.NewReader(data),
-				OnSuccess:  successFunc,
-				OnFailure:  failureFunc,
-			}); err != nil {
-				logger.Error("%s", err.Error())
-			}
+		if dbs.requiresIndexResolution(ent.TABLE(), ent.KEY()) {
+			pattern := dbs.indexPatternFromTable(ent.TABLE(), ent.KEY())
+			ids[pattern] = append(ids[pattern], ent.ID())
 		}
 	}
 
-	if err = bi.Close(context.Background()); err != nil {
-		return int64(affected), err
+	result := make(map[string]map[string][]string)
+	for pattern, list := range ids {
+		if indices, err := dbs.resolveIndices(pattern, list); err != nil {
+			return nil, err
+		} else {
+			result[pattern] = indices
+		}
 	}
-	return int64(affected), nil
+	return result, nil
 }
 
-// BulkDelete delete multiple entities by IDs
-func (dbs *ElasticStore) BulkDelete(factory EntityFactory, entityIDs []string, keys ...string) (int64, error) {
-	if len(entityIDs) == 0 {
-		return 0, nil
+// Resolve the concrete indices of the table documents, returns nil if the index is derived from the table name
+// (see requiresIndexResolution), otherwise map of document ID -> index names, the latest first (see resolveIndices)
+func (dbs *ElasticStore) documentIndices(table string, ids []string, keys ...string) (map[string][]string, error) {
+	if !dbs.requiresIndexResolution(table, keys...) {
+		return nil, nil
 	}
+	return dbs.resolveIndices(dbs.indexPatternFromTable(table, keys...), ids)
+}
 
-	index := indexName(factory().TABLE(), keys...)
+// Resolve the concrete indices of the documents in the index pattern using batched ids queries
+// Returns map of document ID -> index names (missing documents are not included), if a document exists in
+// multiple indices all of them are returned, the latest index (by name) first
+func (dbs *ElasticStore) resolveIndices(pattern string, ids []string) (map[string][]string, error) {
 
-	// Get bulk indexer
-	bi, err := dbs.getBulkIndexer(index)
-	if err != nil {
-		return 0, err
-	}
+	result := make(map[string][]string)
+	noSource := false
+
+	for start := 0; start < len(ids); start += resolveIndicesBatchSize {
+		end := start + resolveIndicesBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		batch := ids[start:end]
+
+		// Same document may exist in multiple indices, fetch pages until all the copies are collected
+		size := 2 * len(batch)
+		for from := 0; ; from += size {
+			req := &search.Request{
+				Size:    &size,
+				From:    &from,
+				Query:   &types.Query{Ids: &types.IdsQuery{Values: batch}},
+				Sort:    []types.SortCombinations{"_doc"},
+				Source_: noSource,
+			}
 
-	affected := uint64(0)
-	failed := uint64(0)
+			res, err := dbs.tClient.Search().
+				Index(pattern).
+				ExpandWildcards(expandwildcard.All).
+				AllowNoIndices(true).
+				IgnoreUnavailable(true).
+				Request(req).
+				Do(dbs.getContext())
+			if err != nil {
+				return nil, ElasticError(err)
+			}
 
-	successFunc := func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
-		atomic.AddUint64(&affected, 1)
+			for _, hit := range res.Hits.Hits {
+				result[hit.Id_] = append(result[hit.Id_], hit.Index_)
+			}
+			if len(res.Hits.Hits) < size || res.Hits.Total == nil || int64(from+len(res.Hits.Hits)) >= res.Hits.Total.Value {
+				break
+			}
+		}
 	}
 
-	failureFunc := func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
-		atomic.AddUint64(&failed, 1)
-		if err != nil {
-			logger.Error("OnFailure error: %s", err.Error())
-		} else {
-			logger.Error("OnFailure error: %s: %s", res.Error.Type, res.Error.Reason)
-		}
+	for _, indices := range result {
+		sort.Sort(sort.Reverse(sort.StringSlice(indices)))
 	}
+	return result, nil
+}
 
-	for _, entId := range entityIDs {
-		if err = bi.Add(context.Background(), esutil.BulkIndexerItem{
-			Index:      index,
-			Action:     "delete",
-			DocumentID: entId,
-			OnSuccess:  successFunc,
-			OnFailure:  failureFunc,
-		}); err != nil {
-			logger.Error("%s", err.Error())
+// Execute the bulk items and collect the results
+// Items failed with retriable errors are retried with exponential backoff, items which keep failing are sent to the
+// dead letter sink (if configured)
+func (dbs *ElasticStore) executeBulk(index string, items []bulkItem, result *BulkResult, opts BulkOptions) (*BulkResult, error) {
+
+	ctx := dbs.getContext()
+	pending := items
+	failures := make([]bulkItemFailure, 0)
+	var err error
+
+	for attempt := 0; len(pending) > 0; attempt++ {
+
+		if attempt > 0 {
+			result.Retried += int64(len(pending))
+			if err = sleepContext(ctx, itemRetryBackoff(opts.ItemRetryBackoff, attempt)); err != nil {
+				// Context canceled, the pending items are considered as failed
+				for _, item := range pending {
+					failures = append(failures, bulkItemFailure{item: item, attempts: attempt,
+						failure: BulkItemFailure{ID: item.id, Index: item.index, Action: item.action, Err: err}})
+				}
+				break
+			}
 		}
 
+		succeeded, failed, er := dbs.runBulk(ctx, index, pending, opts)
+		result.Succeeded += succeeded
+		if attempt > 0 {
+			result.Recovered += succeeded
+		}
+		if er != nil {
+			err = er
+		}
+
+		pending = nil
+		for _, f := range failed {
+			f.attempts = attempt + 1
+			if f.item.action == "delete" && f.failure.Status == http.StatusNotFound {
+				// Document to delete does not exist, not considered as failure
+				result.NotFound = append(result.NotFound, f.item.id)
+				continue
+			}
+			if attempt < opts.MaxItemRetries && f.failure.retriable() && ctx.Err() == nil {
+				pending = append(pending, f.item)
+			} else {
+				failures = append(failures, f)
+			}
+		}
 	}
 
-	if err = bi.Close(context.Background()); err != nil {
-		return int64(affected), err
+	for _, f := range failures {
+		result.Failed++
+		result.Failures = append(result.Failures, f.failure)
 	}
-	return int64(affected), nil
+
+	// Send the failed items to the dead letter sink
+	if opts.DeadLetter != nil && len(failures) > 0 {
+		if er := opts.DeadLetter.Write(newDeadLetters(failures)); er != nil {
+			if err == nil {
+				err = fmt.Errorf("dead letter sink: %w", er)
+			}
+		} else {
+			result.DeadLettered += int64(len(failures))
+		}
+	}
+	return result, err
 }
 
-// BulkSetFields Update specific field of multiple entities in a single transaction (eliminates the need to fetch - change - update)
-// The field is the name of the field, values is a map of entityId -> field value
-func (dbs *ElasticStore) BulkSetFields(factory EntityFactory, field string, values map[string]any, keys ...string) (int64, error) {
+// bulkItemFailure holds the failed item (for retry and dead letter) and the failure details
+type bulkItemFailure struct {
+	item     bulkItem
+	failure  BulkItemFailure
+	attempts int
+	pos      int // Position of the item in the bulk run
+}
 
-	if len(values) == 0 {
-		return 0, nil
+// Execute a single bulk run of the items, returns the number of succeeded items and the failed items
+// Items which were not acknowledged by elasticsearch (e.g. the bulk request failed) are considered as failed
+func (dbs *ElasticStore) runBulk(ctx context.Context, index string, items []bulkItem, opts BulkOptions) (int64, []bulkItemFailure, error) {
+
+	if len(items) == 0 {
+		return 0, nil, nil
 	}
 
-	index := indexName(factory().TABLE(), keys...)
+	var mu sync.Mutex
+	var flushErr error
+	succeeded := int64(0)
+	acked := make([]bool, len(items))
+	failed := make([]bulkItemFailure, 0)
 
 	// Get bulk indexer
-	bi, err := dbs.getBulkIndexer(index)
+	bi, err := dbs.getBulkIndexer(ctx, index, opts, func(er error) {
+		mu.Lock()
+		defer mu.Unlock()
+		flushErr = er
+	})
 	if err != nil {
-		return 0, err
+		return 0, nil, err
 	}
 
-	affected := uint64(0)
-	failed := uint64(0)
-
-	successFunc := func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
-		atomic.AddUint64(&affected, 1)
+	for i, item := range items {
+		i, item := i, item
+		bii := esutil.BulkIndexerItem{
+			Index:      item.index,
+			Action:     item.action,
+			DocumentID: item.id,
+
+			RetryOnConflict: item.retryOnConflict,
+			OnSuccess: func(ctx context.Context, _ esutil.BulkIndexerItem, _ esutil.BulkIndexerResponseItem) {
+				mu.Lock()
+				defer mu.Unlock()
+				acked[i] = true
+				succeeded++
+			},
+			OnFailure: func(ctx context.Context, bii esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, er error) {
+				mu.Lock()
+				defer mu.Unlock()
+				acked[i] = true
+				failed = append(failed, bulkItemFailure{item: item, failure: newBulkItemFailure(bii, res, er), pos: i})
+			},
+		}
+		if item.body != nil {
+			bii.Body = bytes.NewReader(item.body)
+		}
+		if err = bi.Add(ctx, bii); err != nil {
+			// The indexer is closed (e.g. context canceled)
+			break
+		}
 	}
 
-	failureFunc := func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
-		atomic.AddUint64(&failed, 1)
-		if err != nil {
-			logger.Error("OnFailure error: %s", err.Error())
-		} else {
-			logger.Error("OnFailure error: %s: %s", res.Error.Type, res.Error.Reason)
-		}
+	if er := bi.Close(ctx); er != nil && err == nil {
+		err = er
 	}
 
-	for id, val := range values {
-		data := fmt.Sprintf(`{"doc":{"%s":"%v"}}`, field, val)
-		if err = bi.Add(context.Background(), esutil.BulkIndexerItem{
-			Index:      index,
-			Action:     "update",
-			DocumentID: id,
-			Body:       strings.NewReader(data),
-			OnSuccess:  successFunc,
-			OnFailure:  failureFunc,
-		}); err != nil {
-			logger.Error("%s", err.Error())
+	// Items which were not acknowledged
+	mu.Lock()
+	defer mu.Unlock()
+	for i, item := range items {
+		if !acked[i] {
+			cause := flushErr
+			if cause == nil {
+				cause = err
+			}
+			if cause == nil {
+				cause = errors.New("bulk item was not acknowledged")
+			}
+			failed = append(failed, bulkItemFailure{item: item, pos: i,
+				failure: BulkItemFailure{ID: item.id, Index: item.index, Action: item.action, Err: ElasticError(cause)}})
 		}
 	}
+	return succeeded, failed, err
+}
 
-	if err = bi.Close(context.Background()); err != nil {
-		return int64(affected), err
+// Get the backoff duration of the retry attempt (exponential backoff starting at the initial duration)
+func itemRetryBackoff(initial time.Duration, attempt int) time.Duration {
+	if initial <= 0 {
+		initial = defaultItemRetryBackoff
+	}
+	backoff := initial << (attempt - 1)
+	if backoff > maxItemRetryBackoff || backoff <= 0 {
+		backoff = maxItemRetryBackoff
 	}
-	return int64(affected), nil
+	return backoff
 }
 
-// endregion
+// Sleep for the duration or until the context is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
 
-// region Datastore bulk helper methods --------------------------------------------------------------------------------
+// Get the store bulk settings overridden by the bulk options
+func (dbs *ElasticStore) bulkOptions(opts ...BulkOption) BulkOptions {
+	result := dbs.cfg.bulk
+	for _, opt := range opts {
+		opt(&result)
+	}
+	return result
+}
 
-// Get bulk indexer
-func (dbs *ElasticStore) getBulkIndexer(indexName string) (esutil.BulkIndexer, error) {
+// Get bulk indexer, the flush requests are bound to the provided context
+// The optional onError callback is called for the indexer errors (e.g. failed flush request)
+func (dbs *ElasticStore) getBulkIndexer(ctx context.Context, indexName string, opts BulkOptions, onError func(err error)) (esutil.BulkIndexer, error) {
 
 	// _ = dbs.verifyIndex(indexName)
 
 	bi, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
-		Index:         indexName,       // The default index name
-		Client:        dbs.esClient,    // The Elasticsearch client
-		FlushInterval: 2 * time.Second, // The periodic flush interval
+		Index:         indexName,          // The default index name
+		Client:        dbs.esClient,       // The Elasticsearch client
+		NumWorkers:    opts.NumWorkers,    // The number of workers
+		FlushBytes:    opts.FlushBytes,    // The flush threshold in bytes
+		FlushInterval: opts.FlushInterval, // The periodic flush interval
+		Refresh:       opts.Refresh,       // The refresh policy
+		Pipeline:      opts.Pipeline,      // The ingest pipeline
+		Timeout:       opts.Timeout,       // The bulk request timeout
+		Routing:       opts.Routing,       // The custom routing
+
+		// Propagate the caller context to the flush requests
+		OnFlushStart: func(_ context.Context) context.Context {
+			return ctx
+		},
+
+		OnError: func(_ context.Context, err error) {
+			if onError != nil {
+				onError(err)
+			}
+		},
 	})
 	return bi, err
 }
@@ -0,0 +1,155 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	. "github.com/go-yaaf/yaaf-common/database"
+	. "github.com/go-yaaf/yaaf-common/entity"
+)
+
+// region Dead letter definitions --------------------------------------------------------------------------------------
+
+// DeadLetter holds a bulk item which failed permanently (after retries)
+type DeadLetter struct {
+	BaseEntity
+	DocumentID string `json:"documentId"` // The document ID
+	Index      string `json:"index"`      // The target index
+	Action     string `json:"action"`     // The bulk action (index, create, update, delete)
+	Status     int    `json:"status"`     // HTTP status of the item (0 if the item was not acknowledged)
+	Type       string `json:"type"`       // Elasticsearch error type
+	Reason     string `json:"reason"`     // Elasticsearch error reason
+	Error      string `json:"error"`      // The error message
+	Attempts   int    `json:"attempts"`   // Number of attempts
+	Document   string `json:"document"`   // The document body (JSON)
+
+	table string // The dead letter table (used by the datastore sink)
+}
+
+// TABLE returns the dead letter table name
+func (d *DeadLetter) TABLE() string { return d.table }
+
+// NAME returns the dead letter entity name
+func (d *DeadLetter) NAME() string { return "dead-letter" }
+
+// NewDeadLetter is a factory method for dead letter entity
+func NewDeadLetter() Entity {
+	return &DeadLetter{}
+}
+
+// DeadLetterSink is a destination for the bulk items which failed permanently
+type DeadLetterSink interface {
+	// Write the dead letters to the sink
+	Write(letters []*DeadLetter) error
+}
+
+// DeadLetterFunc is a function adapter for the DeadLetterSink interface
+type DeadLetterFunc func(letters []*DeadLetter) error
+
+// Write the dead letters by calling the function
+func (f DeadLetterFunc) Write(letters []*DeadLetter) error {
+	return f(letters)
+}
+
+// Create dead letters from the failed items
+func newDeadLetters(failures []bulkItemFailure) []*DeadLetter {
+	now := Now()
+	letters := make([]*DeadLetter, 0, len(failures))
+	for _, f := range failures {
+		letter := &DeadLetter{
+			BaseEntity: BaseEntity{Id: ID(), CreatedOn: now, UpdatedOn: now},
+			DocumentID: f.failure.ID,
+			Index:      f.failure.Index,
+			Action:     f.failure.Action,
+			Status:     f.failure.Status,
+			Type:       f.failure.Type,
+			Reason:     f.failure.Reason,
+			Attempts:   f.attempts,
+			Document:   string(f.item.body),
+		}
+		if f.failure.Err != nil {
+			letter.Error = f.failure.Err.Error()
+		}
+		letters = append(letters, letter)
+	}
+	return letters
+}
+
+// endregion
+
+// region File dead letter sink ----------------------------------------------------------------------------------------
+
+// FileDeadLetterSink appends the dead letters to a file as JSON lines
+type FileDeadLetterSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileDeadLetterSink creates a dead letter sink appending to the file (the file is created if it does not exist)
+func NewFileDeadLetterSink(path string) (*FileDeadLetterSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileDeadLetterSink{file: file}, nil
+}
+
+// Write the dead letters to the file, one JSON document per line
+func (s *FileDeadLetterSink) Write(letters []*DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	encoder := json.NewEncoder(s.file)
+	for _, letter := range letters {
+		if err := encoder.Encode(letter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close the file
+func (s *FileDeadLetterSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// endregion
+
+// region Datastore dead letter sink -----------------------------------------------------------------------------------
+
+// DatastoreDeadLetterSink writes the dead letters to a datastore table
+type DatastoreDeadLetterSink struct {
+	ds    IDatastore
+	table string
+}
+
+// NewDatastoreDeadLetterSink creates a dead letter sink writing to the table of the datastore
+// The table name may include templates, for example: dead-letters-{YYYY}.{MM}
+func NewDatastoreDeadLetterSink(ds IDatastore, table string) *DatastoreDeadLetterSink {
+	return &DatastoreDeadLetterSink{ds: ds, table: table}
+}
+
+// Write the dead letters to the datastore
+func (s *DatastoreDeadLetterSink) Write(letters []*DeadLetter) error {
+	entities := make([]Entity, 0, len(letters))
+	for _, letter := range letters {
+		letter.table = s.table
+		entities = append(entities, letter)
+	}
+
+	// Avoid endless loop when the dead letter sink itself fails
+	if dbs, ok := s.ds.(*ElasticStore); ok {
+		result, err := dbs.BulkInsertWithResult(entities, BulkDeadLetter(nil))
+		if err != nil {
+			return err
+		}
+		return result.Err()
+	}
+	_, err := s.ds.BulkInsert(entities)
+	return err
+}
+
+// endregion
@@ -0,0 +1,522 @@
+package elasticsearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/go-yaaf/yaaf-common/entity"
+)
+
+// region Bulk writer definitions --------------------------------------------------------------------------------------
+
+const (
+	minBackpressurePause = 100 * time.Millisecond // Initial pause when elasticsearch rejects items (429)
+	maxBackpressurePause = 10 * time.Second       // Maximum pause when elasticsearch keeps rejecting items
+	defaultFlushBytes    = 5e+6                   // Default flush threshold in bytes of the writer batches
+	defaultFlushInterval = 30 * time.Second       // Default periodic flush interval of the writer batches
+)
+
+// ErrBulkWriterClosed is returned when adding items to a closed bulk writer
+var ErrBulkWriterClosed = errors.New("bulk writer is closed")
+
+// BulkWriterCallbacks holds the callbacks to report the results of the bulk writer items
+// The callbacks are called from the writer workers and must be safe for concurrent use
+type BulkWriterCallbacks struct {
+	OnSuccess func(id, index, action string) // Called for each successful item (optional)
+	OnFailure func(failure BulkItemFailure)  // Called for each failed item (optional)
+	OnError   func(err error)                // Called for failed flush requests, e.g. elasticsearch is not available (optional)
+}
+
+// BulkWriterStats holds the counters of the bulk writer
+type BulkWriterStats struct {
+	Added        int64 // Number of items added to the writer
+	Succeeded    int64 // Number of successful items
+	Failed       int64 // Number of failed items (after retries)
+	Throttled    int64 // Number of items rejected by elasticsearch (429) which triggered backpressure
+	Retried      int64 // Number of item retries
+	Recovered    int64 // Number of items succeeded after retry
+	DeadLettered int64 // Number of failed items written to the dead letter sink
+}
+
+// BulkWriter is a long-lived bulk writer, items are added over time and flushed by size and time in the background
+// When elasticsearch rejects items (429 Too Many Requests) the writer pauses accepting new items with exponential backoff
+// Rejected items are re-queued according to the retry settings, items which keep failing are sent to the dead letter sink
+type BulkWriter struct {
+	dbs          *ElasticStore       // The data store
+	ctx          context.Context     // The writer context (bound to the flush requests)
+	shards       []*bulkWriterShard  // The writer workers (each worker buffers and flushes its own batch)
+	next         atomic.Uint64       // Round robin counter of the shards
+	opts         BulkOptions         // The bulk settings
+	callbacks    BulkWriterCallbacks // The result callbacks
+	closed       atomic.Bool         // Set when the writer is closed
+	added        atomic.Int64        // Number of added items
+	succeeded    atomic.Int64        // Number of successful items
+	failed       atomic.Int64        // Number of failed items
+	throttled    atomic.Int64        // Number of throttled items
+	retried      atomic.Int64        // Number of item retries
+	recovered    atomic.Int64        // Number of items succeeded after retry
+	deadLettered atomic.Int64        // Number of dead lettered items
+	retries      sync.WaitGroup      // Pending item retries
+	lifecycle    sync.RWMutex        // Held for read by add and for write by Close, no item is added to a closed queue
+	mu           sync.Mutex          // Guards the backpressure and draining state
+	draining     bool                // Set when closing, failed items are not retried
+	pause        time.Duration       // Current backpressure pause
+	resumeAt     time.Time           // Time to resume accepting items
+}
+
+// bulkWriterShard is a single worker of the writer, the worker buffers the items and flushes the batch by size and time
+// Each flush is a single bulk run, so every item of the batch is acknowledged: succeeded, failed by elasticsearch or
+// failed with the flush request error (e.g. elasticsearch is not available)
+type bulkWriterShard struct {
+	queue chan *bulkWriterItem // The items to buffer, closed when the writer is closed
+	done  chan struct{}        // Closed when the worker flushed the last batch
+}
+
+// bulkWriterItem is an item attempt
+type bulkWriterItem struct {
+	item    bulkItem
+	attempt int
+	pattern string // Index pattern to resolve the current indices of the document at flush time (empty if not required)
+}
+
+// endregion
+
+// region Bulk writer methods ------------------------------------------------------------------------------------------
+
+// NewBulkWriter creates a long-lived bulk writer, the store bulk settings can be overridden by the bulk options
+// The writer is bound to the store context, it must be closed to flush the pending items and release the workers
+func (dbs *ElasticStore) NewBulkWriter(callbacks BulkWriterCallbacks, opts ...BulkOption) (*BulkWriter, error) {
+
+	w := &BulkWriter{dbs: dbs, ctx: dbs.getContext(), opts: dbs.bulkOptions(opts...), callbacks: callbacks}
+
+	workers := w.opts.NumWorkers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	for i := 0; i < workers; i++ {
+		shard := &bulkWriterShard{queue: make(chan *bulkWriterItem), done: make(chan struct{})}
+		w.shards = append(w.shards, shard)
+		go w.runShard(shard)
+	}
+	return w, nil
+}
+
+// Insert adds an entity to be indexed (created or replaced)
+func (w *BulkWriter) Insert(entity Entity) error {
+	return w.Add("index", entity)
+}
+
+// Update adds an entity to be updated as a partial document (fails if the document does not exist)
+// For time partitioned tables the document is updated in its current index, resolved when the batch is flushed
+func (w *BulkWriter) Update(entity Entity) error {
+	return w.Add("update", entity)
+}
+
+// Upsert adds an entity to be updated as a partial document or created if it does not exist
+// For time partitioned tables an entity which provides its time is written to the index of its time (see Upsert),
+// otherwise existing documents are updated in their current index, resolved when the batch is flushed, and new
+// documents are created in the current index
+func (w *BulkWriter) Upsert(entity Entity) error {
+	data, err := Marshal(entity)
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf(`{"doc":%s,"doc_as_upsert":true}`, data)
+	item := bulkItem{index: w.dbs.entityIndexName(entity), action: "update", id: entity.ID(), body: []byte(body), retryOnConflict: &w.dbs.cfg.retryOnConflict}
+	if _, ok := w.dbs.entityTime(entity); ok {
+		return w.add(item, "")
+	}
+	return w.add(item, w.resolvePattern(entity.TABLE(), entity.KEY()))
+}
+
+// Delete adds a document to be deleted by ID
+// For time partitioned tables the document is deleted from all the indices it exists in, resolved when the batch is
+// flushed (the current index of the table if the document is not found)
+func (w *BulkWriter) Delete(factory EntityFactory, entityID string, keys ...string) error {
+	table := factory().TABLE()
+	return w.add(bulkItem{index: w.dbs.indexName(table, keys...), action: "delete", id: entityID}, w.resolvePattern(table, keys...))
+}
+
+// Add adds an entity with the bulk action: index, create, update (partial document) or delete
+// The entity is written to the index of the entity time (see IndexTimeProvider and WithIndexTimeField), updated and
+// deleted documents of time partitioned tables are resolved in their current indices when the batch is flushed
+// Documents which are not found yet (e.g. added by the writer and not refreshed) are written to the entity time index
+func (w *BulkWriter) Add(action string, entity Entity) error {
+
+	item := bulkItem{index: w.dbs.entityIndexName(entity), action: action, id: entity.ID()}
+	pattern := w.resolvePattern(entity.TABLE(), entity.KEY())
+
+	switch action {
+	case "delete":
+		return w.add(item, pattern)
+	case "index", "create", "update":
+		data, err := Marshal(entity)
+		if err != nil {
+			return err
+		}
+		if action != "update" {
+			item.body = data
+			return w.add(item, "")
+		}
+		item.body = []byte(fmt.Sprintf(`{"doc":%s}`, data))
+		item.retryOnConflict = &w.dbs.cfg.retryOnConflict
+		return w.add(item, pattern)
+	default:
+		return fmt.Errorf("unsupported bulk action: %s", action)
+	}
+}
+
+// Get the index pattern to resolve the current indices of updated and deleted documents when the batch is flushed,
+// empty if the index is derived from the table name (see requiresIndexResolution)
+func (w *BulkWriter) resolvePattern(table string, keys ...string) string {
+	if !w.dbs.requiresIndexResolution(table, keys...) {
+		return ""
+	}
+	return w.dbs.indexPatternFromTable(table, keys...)
+}
+
+// Stats returns the writer counters
+func (w *BulkWriter) Stats() BulkWriterStats {
+	return BulkWriterStats{
+		Added:        w.added.Load(),
+		Succeeded:    w.succeeded.Load(),
+		Failed:       w.failed.Load(),
+		Throttled:    w.throttled.Load(),
+		Retried:      w.retried.Load(),
+		Recovered:    w.recovered.Load(),
+		DeadLettered: w.deadLettered.Load(),
+	}
+}
+
+// Close flushes the pending items, waits for the workers and the pending retries to complete and releases the writer
+// Items which fail while the writer is closing are not retried
+func (w *BulkWriter) Close() error {
+	if !w.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	// Wait for the in-flight adds (e.g. blocked by backpressure)
+	w.lifecycle.Lock()
+	defer w.lifecycle.Unlock()
+
+	w.mu.Lock()
+	w.draining = true
+	w.mu.Unlock()
+
+	w.retries.Wait()
+	return w.closeShards()
+}
+
+// Close the queues of the shards and wait for the workers to flush the last batches
+func (w *BulkWriter) closeShards() error {
+	for _, shard := range w.shards {
+		close(shard.queue)
+	}
+	for _, shard := range w.shards {
+		<-shard.done
+	}
+	return nil
+}
+
+// Add item to the writer, blocks while the writer is paused by backpressure
+func (w *BulkWriter) add(item bulkItem, pattern string) error {
+
+	w.lifecycle.RLock()
+	defer w.lifecycle.RUnlock()
+
+	if w.closed.Load() {
+		return ErrBulkWriterClosed
+	}
+	if err := w.waitForBackpressure(); err != nil {
+		return err
+	}
+	if w.closed.Load() {
+		return ErrBulkWriterClosed
+	}
+	if err := w.addItem(&bulkWriterItem{item: item, attempt: 1, pattern: pattern}); err != nil {
+		return err
+	}
+	w.added.Add(1)
+	return nil
+}
+
+// Add item attempt to the queue of the next shard, blocks while the shard worker is flushing
+func (w *BulkWriter) addItem(wi *bulkWriterItem) error {
+	shard := w.shards[int(w.next.Add(1)%uint64(len(w.shards)))]
+	select {
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	case shard.queue <- wi:
+		return nil
+	}
+}
+
+// Run the shard worker: buffer the items and flush the batch when it reaches the flush bytes or on the flush interval
+func (w *BulkWriter) runShard(shard *bulkWriterShard) {
+	defer close(shard.done)
+
+	flushBytes, flushInterval := w.opts.FlushBytes, w.opts.FlushInterval
+	if flushBytes <= 0 {
+		flushBytes = defaultFlushBytes
+	}
+	if flushInterval <= 0 {
+		flushInterval = defaultFlushInterval
+	}
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*bulkWriterItem, 0)
+	size := 0
+	for {
+		select {
+		case wi, ok := <-shard.queue:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			batch = append(batch, wi)
+			if size += wi.item.payloadSize(); size >= flushBytes {
+				w.flush(batch)
+				batch, size = make([]*bulkWriterItem, 0), 0
+				ticker.Reset(flushInterval)
+			}
+		case <-ticker.C:
+			w.flush(batch)
+			batch, size = make([]*bulkWriterItem, 0), 0
+		}
+	}
+}
+
+// Flush the batch in a single bulk run and report the result of every item
+// The current indices of the updated and deleted documents are resolved for the whole batch (see resolveBatch)
+// Items which were not acknowledged (failed flush request) are handled as failed items (retried or dead lettered)
+// and the flush error is reported once by the OnError callback
+func (w *BulkWriter) flush(batch []*bulkWriterItem) {
+	if len(batch) == 0 {
+		return
+	}
+
+	// The bulk items of each batch item: a document is deleted from all the indices it exists in
+	resolved, resolveErr := w.resolveBatch(batch)
+	items := make([]bulkItem, 0, len(batch))
+	owners := make([]int, 0, len(batch))
+	for i, wi := range batch {
+		if copies, err := wi.copies(resolved, resolveErr); err != nil {
+			w.onFailure(wi, BulkItemFailure{ID: wi.item.id, Index: wi.item.index, Action: wi.item.action, Err: err})
+		} else {
+			for _, item := range copies {
+				items = append(items, item)
+				owners = append(owners, i)
+			}
+		}
+	}
+
+	opts := w.opts
+	opts.NumWorkers = 1
+	_, failed, err := w.dbs.runBulk(w.ctx, "", items, opts)
+
+	failures := make(map[int][]bulkItemFailure)
+	for _, f := range failed {
+		failures[owners[f.pos]] = append(failures[owners[f.pos]], f)
+		if f.failure.Status == 0 && err == nil {
+			err = f.failure.Err
+		}
+	}
+	if err != nil && w.callbacks.OnError != nil {
+		w.callbacks.OnError(err)
+	}
+
+	// The batch item succeeded if all its bulk items succeeded, otherwise each failed bulk item is handled on its own
+	first := make(map[int]bulkItem, len(batch))
+	for pos := len(items) - 1; pos >= 0; pos-- {
+		first[owners[pos]] = items[pos]
+	}
+	for i, wi := range batch {
+		if item, sent := first[i]; !sent {
+			continue
+		} else if list, ok := failures[i]; !ok {
+			w.onSuccess(item, wi.attempt)
+		} else {
+			for _, f := range list {
+				w.onFailure(&bulkWriterItem{item: f.item, attempt: wi.attempt}, f.failure)
+			}
+		}
+	}
+}
+
+// Resolve the current indices of the batch documents which require index resolution, a single search per index pattern
+// Returns map of index pattern -> document ID -> index names, the latest first (see resolveIndices)
+func (w *BulkWriter) resolveBatch(batch []*bulkWriterItem) (map[string]map[string][]string, error) {
+
+	ids := make(map[string][]string)
+	seen := make(map[string]bool)
+	for _, wi := range batch {
+		if len(wi.pattern) > 0 && !seen[wi.pattern+"/"+wi.item.id] {
+			seen[wi.pattern+"/"+wi.item.id] = true
+			ids[wi.pattern] = append(ids[wi.pattern], wi.item.id)
+		}
+	}
+
+	result := make(map[string]map[string][]string)
+	for pattern, list := range ids {
+		if indices, err := w.dbs.resolveIndices(pattern, list); err != nil {
+			return nil, err
+		} else {
+			result[pattern] = indices
+		}
+	}
+	return result, nil
+}
+
+// Get the bulk items of the writer item by the resolved indices: an update is sent to the latest index of the document
+// and a delete to all of its indices. Documents which are not found (not refreshed yet) keep the item index
+func (wi *bulkWriterItem) copies(resolved map[string]map[string][]string, err error) ([]bulkItem, error) {
+	if len(wi.pattern) == 0 {
+		return []bulkItem{wi.item}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	indices, ok := resolved[wi.pattern][wi.item.id]
+	if !ok {
+		return []bulkItem{wi.item}, nil
+	}
+	if wi.item.action != "delete" {
+		indices = indices[:1]
+	}
+	result := make([]bulkItem, 0, len(indices))
+	for _, index := range indices {
+		item := wi.item
+		item.index = index
+		result = append(result, item)
+	}
+	return result, nil
+}
+
+// Successful item callback
+func (w *BulkWriter) onSuccess(item bulkItem, attempt int) {
+	w.succeeded.Add(1)
+	if attempt > 1 {
+		w.recovered.Add(1)
+	}
+	w.resetBackpressure()
+	if w.callbacks.OnSuccess != nil {
+		w.callbacks.OnSuccess(item.id, item.index, item.action)
+	}
+}
+
+// Failed item callback, retriable items are re-queued after backoff
+// A failed item which was not resolved is retried with resolution, the resolved bulk items are retried as is
+func (w *BulkWriter) onFailure(wi *bulkWriterItem, failure BulkItemFailure) {
+	item, attempt := wi.item, wi.attempt
+	if failure.Status == http.StatusTooManyRequests {
+		w.throttled.Add(1)
+		w.applyBackpressure()
+	}
+
+	if failure.retriable() && attempt <= w.opts.MaxItemRetries && w.scheduleRetry() {
+		w.retried.Add(1)
+		go func() {
+			defer w.retries.Done()
+			if err := sleepContext(w.ctx, itemRetryBackoff(w.opts.ItemRetryBackoff, attempt)); err != nil {
+				failure.Err = err
+				w.fail(item, attempt, failure)
+			} else if err = w.addItem(&bulkWriterItem{item: item, attempt: attempt + 1, pattern: wi.pattern}); err != nil {
+				failure.Err = err
+				w.fail(item, attempt, failure)
+			}
+		}()
+		return
+	}
+	w.fail(item, attempt, failure)
+}
+
+// Register pending retry, returns false if the writer is closing
+func (w *BulkWriter) scheduleRetry() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.draining {
+		return false
+	}
+	w.retries.Add(1)
+	return true
+}
+
+// Report permanently failed item and send it to the dead letter sink
+func (w *BulkWriter) fail(item bulkItem, attempt int, failure BulkItemFailure) {
+	w.failed.Add(1)
+	if w.callbacks.OnFailure != nil {
+		w.callbacks.OnFailure(failure)
+	}
+	if w.opts.DeadLetter == nil {
+		return
+	}
+	letters := newDeadLetters([]bulkItemFailure{{item: item, failure: failure, attempts: attempt}})
+	if err := w.opts.DeadLetter.Write(letters); err != nil {
+		if w.callbacks.OnError != nil {
+			w.callbacks.OnError(fmt.Errorf("dead letter sink: %w", err))
+		}
+	} else {
+		w.deadLettered.Add(1)
+	}
+}
+
+// Get the estimated size of the item in the bulk request body (action metadata and document)
+func (item bulkItem) payloadSize() int {
+	return len(item.action) + len(item.index) + len(item.id) + len(item.body) + 32
+}
+
+// endregion
+
+// region Bulk writer backpressure -------------------------------------------------------------------------------------
+
+// Pause accepting new items, the pause is doubled on every rejection up to the maximum pause
+func (w *BulkWriter) applyBackpressure() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pause = w.pause * 2
+	if w.pause < minBackpressurePause {
+		w.pause = minBackpressurePause
+	}
+	if w.pause > maxBackpressurePause {
+		w.pause = maxBackpressurePause
+	}
+	w.resumeAt = time.Now().Add(w.pause)
+}
+
+// Reset the backpressure pause after a successful item
+func (w *BulkWriter) resetBackpressure() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pause = 0
+}
+
+// Wait until the writer resumes accepting items
+func (w *BulkWriter) waitForBackpressure() error {
+	w.mu.Lock()
+	wait := time.Until(w.resumeAt)
+	w.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// endregion
@@ -0,0 +1,77 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/result"
+
+	. "github.com/go-yaaf/yaaf-common/entity"
+)
+
+// region Optimistic concurrency control -------------------------------------------------------------------------------
+
+// Version holds the optimistic concurrency control information of a document
+// The version is returned by GetWithVersion and used by UpdateIfMatch and DeleteIfMatch to make sure the document
+// was not changed by another writer since it was read
+type Version struct {
+	Index       string // The concrete index of the document
+	SeqNo       int64  // The sequence number of the last modification
+	PrimaryTerm int64  // The primary term of the last modification
+}
+
+// String returns the string representation of the version
+func (v Version) String() string {
+	return fmt.Sprintf("%s[%d:%d]", v.Index, v.SeqNo, v.PrimaryTerm)
+}
+
+// GetWithVersion gets a single entity by ID including its concurrency control version
+func (dbs *ElasticStore) GetWithVersion(factory EntityFactory, entityID string, keys ...string) (Entity, Version, error) {
+	pattern := dbs.indexPattern(factory, keys...)
+	entity := factory()
+
+	data, version, err := dbs.getVersioned(pattern, entityID)
+	if err != nil {
+		return nil, version, err
+	}
+	if jer := json.Unmarshal(data, &entity); jer != nil {
+		return nil, version, jer
+	}
+	return entity, version, nil
+}
+
+// UpdateIfMatch updates an existing entity only if it was not changed since the provided version was read
+// If the document was changed (or deleted) by another writer, an error of kind ErrVersionConflict is returned
+// Returns the updated entity and its new version
+func (dbs *ElasticStore) UpdateIfMatch(entity Entity, version Version) (Entity, Version, error) {
+
+	res, err := dbs.tClient.Index(version.Index).Id(entity.ID()).
+		IfSeqNo(strconv.FormatInt(version.SeqNo, 10)).
+		IfPrimaryTerm(strconv.FormatInt(version.PrimaryTerm, 10)).
+		Request(entity).
+		Do(dbs.getContext())
+	if err != nil {
+		return nil, version, ElasticError(err)
+	}
+	return entity, Version{Index: res.Index_, SeqNo: res.SeqNo_, PrimaryTerm: res.PrimaryTerm_}, nil
+}
+
+// DeleteIfMatch deletes an entity only if it was not changed since the provided version was read
+// If the document was changed by another writer, an error of kind ErrVersionConflict is returned
+func (dbs *ElasticStore) DeleteIfMatch(entityID string, version Version) error {
+
+	res, err := dbs.tClient.Delete(version.Index, entityID).
+		IfSeqNo(strconv.FormatInt(version.SeqNo, 10)).
+		IfPrimaryTerm(strconv.FormatInt(version.PrimaryTerm, 10)).
+		Do(dbs.getContext())
+	if err != nil {
+		return ElasticError(err)
+	}
+	if res.Result == result.Notfound {
+		return notFoundError("document: %s not found in index: %s", entityID, version.Index)
+	}
+	return nil
+}
+
+// endregion
@@ -0,0 +1,218 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+)
+
+// region Error taxonomy -----------------------------------------------------------------------------------------------
+
+// Sentinel errors, use errors.Is to check the kind of error returned by the store
+var (
+	ErrNotFound        = errors.New("not found")         // Document not found
+	ErrVersionConflict = errors.New("version conflict")  // Document was changed by another writer
+	ErrIndexNotFound   = errors.New("index not found")   // Index does not exist
+	ErrTooManyRequests = errors.New("too many requests") // Request rejected by the cluster (throttling)
+	ErrTimeout         = errors.New("timeout")           // Request timed out (client or server side)
+	ErrMappingConflict = errors.New("mapping conflict")  // Document does not fit the index mapping
+	ErrCursorExpired   = errors.New("cursor expired")    // Point in time of the cursor expired or was closed
+)
+
+// StoreError is a classified error returned by the store, it wraps the original error (e.g. types.ElasticsearchError)
+// so callers can use errors.Is with the sentinel errors and errors.As to get the original error
+type StoreError struct {
+	Kind   error  // The sentinel error (nil if the error is not classified)
+	Status int    // HTTP status code (0 if not available)
+	Type   string // Elasticsearch error type (e.g. version_conflict_engine_exception)
+	Reason string // Elasticsearch error reason
+	Index  string // Index name (if available)
+	Cause  error  // The original error
+}
+
+// Error returns the error message
+func (e *StoreError) Error() string {
+	if len(e.Reason) > 0 {
+		return e.Reason
+	}
+	if e.Cause != nil {
+		return e.Cause.Error()
+	}
+	if e.Kind != nil {
+		return e.Kind.Error()
+	}
+	return fmt.Sprintf("elasticsearch error, status: %d", e.Status)
+}
+
+// Is reports whether the error is of the target sentinel error kind
+func (e *StoreError) Is(target error) bool {
+	return e.Kind != nil && e.Kind == target
+}
+
+// Unwrap returns the original error
+func (e *StoreError) Unwrap() error {
+	return e.Cause
+}
+
+// endregion
+
+// region Error helper methods -----------------------------------------------------------------------------------------
+
+// ElasticError is wrapper for errors returned by elasticsearch to provide meaningful error
+// Elasticsearch errors are converted to *StoreError classified by the HTTP status and the error type
+func ElasticError(err error) error {
+
+	if err == nil {
+		return nil
+	}
+
+	// Already classified
+	var se *StoreError
+	if errors.As(err, &se) {
+		return err
+	}
+
+	var ee *types.ElasticsearchError
+	if errors.As(err, &ee) {
+		result := &StoreError{Status: ee.Status, Type: ee.ErrorCause.Type, Cause: err}
+
+		// Use the root cause reason when available
+		cause := ee.ErrorCause
+		if len(cause.RootCause) > 0 {
+			cause = cause.RootCause[0]
+			if len(cause.Type) > 0 {
+				result.Type = cause.Type
+			}
+		}
+		if cause.Reason != nil {
+			result.Reason = *cause.Reason
+		} else if ee.ErrorCause.Reason != nil {
+			result.Reason = *ee.ErrorCause.Reason
+		} else {
+			result.Reason = ee.Error()
+		}
+		result.Index = errorCauseIndex(cause)
+		if len(result.Index) == 0 {
+			result.Index = errorCauseIndex(ee.ErrorCause)
+		}
+		result.Kind = classifyError(result.Status, result.Type, result.Reason)
+		return result
+	}
+
+	// Client side timeouts
+	if isTimeout(err) {
+		return &StoreError{Kind: ErrTimeout, Cause: err}
+	}
+	return err
+}
+
+// Build an error from a low level API response (returns nil if the response is not an error)
+func responseError(res *esapi.Response) error {
+	if res == nil || !res.IsError() {
+		return nil
+	}
+
+	body, _ := io.ReadAll(res.Body)
+	ee := types.NewElasticsearchError()
+	if err := json.Unmarshal(body, ee); err != nil || len(ee.ErrorCause.Type) == 0 {
+		// Body is not an elasticsearch error (e.g. proxy error), keep the status
+		return &StoreError{
+			Kind:   classifyError(res.StatusCode, "", ""),
+			Status: res.StatusCode,
+			Reason: fmt.Sprintf("%s: %s", res.Status(), strings.TrimSpace(string(body))),
+		}
+	}
+	if ee.Status == 0 {
+		ee.Status = res.StatusCode
+	}
+	return ElasticError(ee)
+}
+
+// Build an error from a single failure of update / delete by query
+func byQueryFailureError(failure types.BulkIndexByScrollFailure) error {
+	ee := types.NewElasticsearchError()
+	ee.ErrorCause = failure.Cause
+	ee.Status = failure.Status
+	if se, ok := ElasticError(ee).(*StoreError); ok {
+		if len(se.Index) == 0 {
+			se.Index = failure.Index
+		}
+		return se
+	}
+	return ee
+}
+
+// Create a not found error
+func notFoundError(format string, args ...any) error {
+	return &StoreError{Kind: ErrNotFound, Status: http.StatusNotFound, Reason: fmt.Sprintf(format, args...)}
+}
+
+// Classify error by the HTTP status, the elasticsearch error type and reason
+func classifyError(status int, errType, reason string) error {
+
+	switch errType {
+	case "index_not_found_exception":
+		return ErrIndexNotFound
+	case "version_conflict_engine_exception":
+		return ErrVersionConflict
+	case "es_rejected_execution_exception", "circuit_breaking_exception":
+		return ErrTooManyRequests
+	case "timeout_exception", "receive_timeout_transport_exception", "process_cluster_event_timeout_exception":
+		return ErrTimeout
+	case "mapper_parsing_exception", "document_parsing_exception", "strict_dynamic_mapping_exception":
+		return ErrMappingConflict
+	case "illegal_argument_exception":
+		// Mapping update conflicts (e.g. mapper [field] cannot be changed from type [long] to [text])
+		if strings.Contains(reason, "mapper [") {
+			return ErrMappingConflict
+		}
+	case "document_missing_exception", "resource_not_found_exception":
+		return ErrNotFound
+	case "search_context_missing_exception":
+		return ErrCursorExpired
+	}
+
+	switch status {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusConflict:
+		return ErrVersionConflict
+	case http.StatusTooManyRequests:
+		return ErrTooManyRequests
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return ErrTimeout
+	}
+	return nil
+}
+
+// Get the index name from the error cause metadata
+func errorCauseIndex(cause types.ErrorCause) string {
+	raw, ok := cause.Metadata["index"]
+	if !ok {
+		return ""
+	}
+	index := ""
+	if err := json.Unmarshal(raw, &index); err != nil {
+		return ""
+	}
+	return index
+}
+
+// Check if the error is a client side timeout
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// endregion
@@ -0,0 +1,235 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// region Index resolver definitions -----------------------------------------------------------------------------------
+
+// IndexResolver resolves the index names and patterns of the entity tables (the table name is the entity TABLE())
+// The store adds the index prefix (see WithIndexPrefix) to the resolved names and patterns
+type IndexResolver interface {
+	// WriteIndex returns the concrete index name of a new document in the table for the time and shard keys
+	WriteIndex(table string, t time.Time, keys ...string) string
+
+	// ReadPattern returns the index pattern to search the table documents for the shard keys
+	ReadPattern(table string, keys ...string) string
+
+	// TemplatePattern returns the index pattern of the table index template (covers all the table indices)
+	TemplatePattern(table string) string
+
+	// Partitioned reports whether the index of an existing document can't be derived from the table and shard keys
+	// (e.g. time partitioned tables or missing shard keys), the index of existing documents is then resolved by search
+	Partitioned(table string, keys ...string) bool
+}
+
+// IndexRangeResolver is optionally implemented by index resolvers which can list the concrete indices of a time range
+// It is used to narrow the searches with time range on the index time field to the indices overlapping the range
+type IndexRangeResolver interface {
+	// RangeIndices returns the concrete index names of the table which may include documents in the time range,
+	// returns false if the indices can't be listed (e.g. the table is not time partitioned or shard keys are missing)
+	RangeIndices(table string, from, to time.Time, keys ...string) ([]string, bool)
+}
+
+// DefaultIndexResolver resolves the index names by replacing the table name templates:
+//
+//	{year}, {YYYY}: the year (2006)
+//	{month}, {MM}: the month (01)
+//	{YYYY.MM}: the year and month (2006.01)
+//	{day}, {DD}: the day of month (02)
+//	{week}: the ISO week number (01..53)
+//	{quarter}: the quarter (1..4)
+//
+// Any other template (e.g. {accountId}, {region}) is a shard template, shard templates are replaced by the shard keys
+// in order of appearance, for example: events-{accountId}-{region}-{YYYY}.{MM} with keys ("acme", "eu") is written to
+// events-acme-eu-2026.10 and searched by events-acme-eu-*
+type DefaultIndexResolver struct{}
+
+const (
+	maxRangeIndexSteps    = 3660 // Maximum number of time steps (days or months) to list the indices of a time range
+	maxRangeIndicesLength = 3072 // Maximum length of the comma separated indices list (the request line is limited to 4KB)
+)
+
+// Table name templates
+var indexTemplateRegex = regexp.MustCompile(`\{[^{}]+\}`)
+
+// Time templates and their values
+var timeTemplates = map[string]func(t time.Time) string{
+	"{year}":    func(t time.Time) string { return t.Format("2006") },
+	"{YYYY}":    func(t time.Time) string { return t.Format("2006") },
+	"{month}":   func(t time.Time) string { return t.Format("01") },
+	"{MM}":      func(t time.Time) string { return t.Format("01") },
+	"{YYYY.MM}": func(t time.Time) string { return t.Format("2006.01") },
+	"{day}":     func(t time.Time) string { return t.Format("02") },
+	"{DD}":      func(t time.Time) string { return t.Format("02") },
+	"{week}": func(t time.Time) string {
+		_, week := t.ISOWeek()
+		return fmt.Sprintf("%02d", week)
+	},
+	"{quarter}": func(t time.Time) string { return fmt.Sprintf("%d", (int(t.Month())-1)/3+1) },
+}
+
+// endregion
+
+// region Default index resolver methods -------------------------------------------------------------------------------
+
+// WriteIndex replaces the time templates by the time and the shard templates by the keys (missing keys are replaced by empty string)
+func (r DefaultIndexResolver) WriteIndex(table string, t time.Time, keys ...string) string {
+	shard := 0
+	return indexTemplateRegex.ReplaceAllStringFunc(table, func(tmpl string) string {
+		if value, ok := timeTemplates[tmpl]; ok {
+			return value(t)
+		}
+		key := ""
+		if shard < len(keys) {
+			key = keys[shard]
+		}
+		shard++
+		return key
+	})
+}
+
+// ReadPattern replaces the shard templates by the keys, the name is cut at the first time template or missing key
+// and completed by wildcard, for example: hero-{accountId}-{YYYY}.{MM} with key "acme" is searched by hero-acme-*
+// A name without templates (or with all the shard templates resolved) is the exact index name, so the search does not
+// match the indices of other tables sharing the prefix (e.g. hero and heroes)
+func (r DefaultIndexResolver) ReadPattern(table string, keys ...string) string {
+	var sb strings.Builder
+	shard, pos := 0, 0
+	for _, loc := range indexTemplateRegex.FindAllStringIndex(table, -1) {
+		tmpl := table[loc[0]:loc[1]]
+		sb.WriteString(table[pos:loc[0]])
+		if _, ok := timeTemplates[tmpl]; ok || shard >= len(keys) || len(keys[shard]) == 0 {
+			sb.WriteString("*")
+			return sb.String()
+		}
+		sb.WriteString(keys[shard])
+		shard++
+		pos = loc[1]
+	}
+	sb.WriteString(table[pos:])
+	return sb.String()
+}
+
+// TemplatePattern cuts the table name at the first template and completes it by wildcard, for example: hero-*
+// A name without templates is the exact index name
+func (r DefaultIndexResolver) TemplatePattern(table string) string {
+	return r.ReadPattern(table)
+}
+
+// Partitioned reports whether the table includes time templates or shard templates without keys
+func (r DefaultIndexResolver) Partitioned(table string, keys ...string) bool {
+	shard := 0
+	for _, tmpl := range indexTemplateRegex.FindAllString(table, -1) {
+		if _, ok := timeTemplates[tmpl]; ok {
+			return true
+		}
+		if shard >= len(keys) || len(keys[shard]) == 0 {
+			return true
+		}
+		shard++
+	}
+	return false
+}
+
+// RangeIndices lists the concrete index names of the table from the start to the end of the time range
+// The indices are listed by days for tables with day or week templates, otherwise by months
+func (r DefaultIndexResolver) RangeIndices(table string, from, to time.Time, keys ...string) ([]string, bool) {
+
+	if to.Before(from) {
+		return nil, false
+	}
+
+	// All the shard templates must be resolved and at least one time template is required
+	daily, timed, shard := false, false, 0
+	for _, tmpl := range indexTemplateRegex.FindAllString(table, -1) {
+		if _, ok := timeTemplates[tmpl]; ok {
+			timed = true
+			daily = daily || tmpl == "{day}" || tmpl == "{DD}" || tmpl == "{week}"
+			continue
+		}
+		if shard >= len(keys) || len(keys[shard]) == 0 {
+			return nil, false
+		}
+		shard++
+	}
+	if !timed {
+		return nil, false
+	}
+
+	// Iterate over the time range
+	next := func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }
+	t := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, from.Location())
+	if daily {
+		next = func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }
+		t = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+	}
+
+	indices := make([]string, 0)
+	exists := make(map[string]bool)
+	for step := 0; !t.After(to); step++ {
+		if step >= maxRangeIndexSteps {
+			return nil, false
+		}
+		if index := r.WriteIndex(table, t, keys...); !exists[index] {
+			exists[index] = true
+			indices = append(indices, index)
+		}
+		t = next(t)
+	}
+	return indices, true
+}
+
+// endregion
+
+// region Datastore index helper methods -------------------------------------------------------------------------------
+
+// Get the index resolver of the store
+func (dbs *ElasticStore) indexResolver() IndexResolver {
+	if dbs.cfg.indexResolver == nil {
+		return DefaultIndexResolver{}
+	}
+	return dbs.cfg.indexResolver
+}
+
+// Get the field used to narrow the searches with time range to the indices overlapping the range
+// Only the configured index time field is used: without it the documents are written to the index of the current time,
+// so the index of existing documents does not match their time fields (empty means no narrowing)
+func (dbs *ElasticStore) rangeIndexField() string {
+	return dbs.cfg.indexTimeField
+}
+
+// List the concrete indices (with prefix) of the table in the time range, returns false if the resolver can't list
+// the indices or the list is too long for a single request (the index pattern should be used)
+func (dbs *ElasticStore) rangeIndices(table string, from, to time.Time, keys ...string) ([]string, bool) {
+
+	resolver, ok := dbs.indexResolver().(IndexRangeResolver)
+	if !ok {
+		return nil, false
+	}
+
+	indices, ok := resolver.RangeIndices(table, from, to, keys...)
+	if !ok || len(indices) == 0 {
+		return nil, false
+	}
+
+	length := 0
+	for i, index := range indices {
+		indices[i] = dbs.cfg.indexPrefix + index
+		length += len(indices[i]) + 1
+	}
+	if length > maxRangeIndicesLength {
+		return nil, false
+	}
+	return indices, true
+}
+
+// Check if the concrete index of a document can't be derived from the table name and keys (see IndexResolver.Partitioned)
+func (dbs *ElasticStore) requiresIndexResolution(table string, keys ...string) bool {
+	return dbs.indexResolver().Partitioned(table, keys...)
+}
+
+// endregion
@@ -0,0 +1,160 @@
+package elasticsearch
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/go-yaaf/yaaf-common/entity"
+)
+
+// region Index time definitions ---------------------------------------------------------------------------------------
+
+// IndexTimeProvider is implemented by entities which provide the time used to resolve the index of time partitioned
+// tables (e.g. hero-{accountId}-{YYYY}.{MM}), so backfilled and late arriving entities are written to the right index
+type IndexTimeProvider interface {
+	// IndexTime returns the entity time (zero means the current time)
+	IndexTime() Timestamp
+}
+
+// endregion
+
+// region Index time helper methods ------------------------------------------------------------------------------------
+
+// Resolve the index name of the entity, time templates are resolved by the entity time (see entityIndexTime)
+func (dbs *ElasticStore) entityIndexName(entity Entity) string {
+	return dbs.indexNameAt(entity.TABLE(), dbs.entityIndexTime(entity), entity.KEY())
+}
+
+// Resolve the time of the entity used for the index name: the IndexTimeProvider time, the configured index time field
+// or the current time
+func (dbs *ElasticStore) entityIndexTime(entity Entity) time.Time {
+	if t, ok := dbs.entityTime(entity); ok {
+		return t
+	}
+	return time.Now()
+}
+
+// Get the own time of the entity of time partitioned table: the IndexTimeProvider time or the configured index time
+// field, returns false if the entity does not provide its time (the index is resolved by the current time)
+func (dbs *ElasticStore) entityTime(entity Entity) (time.Time, bool) {
+
+	if !dbs.requiresIndexResolution(entity.TABLE(), entity.KEY()) {
+		return time.Time{}, false
+	}
+
+	if provider, ok := entity.(IndexTimeProvider); ok {
+		if ts := provider.IndexTime(); ts > 0 {
+			return time.UnixMilli(int64(ts)), true
+		}
+	}
+
+	if len(dbs.cfg.indexTimeField) > 0 {
+		return fieldTime(entity, dbs.cfg.indexTimeField)
+	}
+	return time.Time{}, false
+}
+
+// Index path of the entity struct fields by JSON name, cached by struct type and field name
+var timeFieldIndex sync.Map
+
+// Key of the time field index cache
+type timeFieldKey struct {
+	t     reflect.Type
+	field string
+}
+
+// Get the time value of the entity field (JSON name), supports epoch milliseconds, RFC3339 strings and time.Time
+// The field is read by reflection, the entity is not marshalled
+func fieldTime(entity Entity, field string) (time.Time, bool) {
+
+	v := reflect.ValueOf(entity)
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return time.Time{}, false
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return time.Time{}, false
+	}
+
+	key := timeFieldKey{t: v.Type(), field: field}
+	index, ok := timeFieldIndex.Load(key)
+	if !ok {
+		index, _ = timeFieldIndex.LoadOrStore(key, jsonFieldIndex(v.Type(), field))
+	}
+	if index.([]int) == nil {
+		return time.Time{}, false
+	}
+
+	fv, err := v.FieldByIndexErr(index.([]int))
+	if err != nil {
+		return time.Time{}, false
+	}
+	for fv.Kind() == reflect.Pointer || fv.Kind() == reflect.Interface {
+		if fv.IsNil() {
+			return time.Time{}, false
+		}
+		fv = fv.Elem()
+	}
+
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return time.UnixMilli(fv.Int()), fv.Int() > 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return time.UnixMilli(int64(fv.Uint())), fv.Uint() > 0
+	case reflect.String:
+		if t, er := time.Parse(time.RFC3339, fv.String()); er == nil {
+			return t, !t.IsZero()
+		}
+	case reflect.Struct:
+		if t, isTime := fv.Interface().(time.Time); isTime {
+			return t, !t.IsZero()
+		}
+	}
+	return time.Time{}, false
+}
+
+// Find the index path of the struct field by JSON name (nil if not found), the fields of embedded structs without JSON
+// name are promoted unless shadowed by a field of the outer struct (as in encoding/json)
+func jsonFieldIndex(t reflect.Type, field string) []int {
+
+	embedded := make([]int, 0)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && len(name) == 0 {
+			if ft := f.Type; ft.Kind() == reflect.Struct || (ft.Kind() == reflect.Pointer && ft.Elem().Kind() == reflect.Struct) {
+				embedded = append(embedded, i)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if len(name) == 0 {
+			name = f.Name
+		}
+		if name == field {
+			return []int{i}
+		}
+	}
+
+	for _, i := range embedded {
+		ft := t.Field(i).Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if index := jsonFieldIndex(ft, field); index != nil {
+			return append([]int{i}, index...)
+		}
+	}
+	return nil
+}
+
+// endregion
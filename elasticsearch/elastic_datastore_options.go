@@ -0,0 +1,506 @@
+package elasticsearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/elastic/elastic-transport-go/v8/elastictransport"
+	elastic "github.com/elastic/go-elasticsearch/v8"
+)
+
+// region Elastic store configuration ----------------------------------------------------------------------------------
+
+// Option is a functional option to configure the elasticsearch data store
+type Option func(cfg *elasticConfig) error
+
+// BulkOptions holds the default settings of the bulk indexer used by the bulk operations
+type BulkOptions struct {
+	NumWorkers    int           // The number of concurrent workers (default: number of CPUs)
+	FlushBytes    int           // The flush threshold in bytes (default: 5MB)
+	FlushInterval time.Duration // The periodic flush interval (default: 2 seconds)
+	Refresh       string        // Refresh policy of the bulk requests: true, false or wait_for (default: index refresh interval)
+	Pipeline      string        // The ingest pipeline to pre-process the documents
+	Timeout       time.Duration // Timeout of a single bulk request on the server side (default: 1 minute)
+	Routing       string        // Custom routing value of the documents
+
+	MaxItemRetries   int            // Number of retries of items rejected by elasticsearch (default: 3, negative disables retries)
+	ItemRetryBackoff time.Duration  // Initial backoff of item retries, doubled on every retry (default: 100ms)
+	DeadLetter       DeadLetterSink // Sink for the items which failed permanently (default: none)
+}
+
+// Bulk refresh policies
+const (
+	RefreshTrue    = "true"     // Refresh the affected shards immediately
+	RefreshFalse   = "false"    // Don't refresh (default)
+	RefreshWaitFor = "wait_for" // Wait for the next periodic refresh before returning
+)
+
+// Elastic store configuration
+type elasticConfig struct {
+	hosts        []string   // List of elasticsearch nodes
+	username     string     // Username for basic authentication
+	password     string     // Password for basic authentication
+	apiKey       string     // Base64 encoded API key (overrides basic authentication and service token)
+	serviceToken string     // Service account bearer token (overrides basic authentication)
+	cloudID      string     // Elastic Cloud deployment ID (replaces the list of hosts)
+	explicitHost bool       // Set when the host is provided by the URI or WithHosts (ELASTIC_CLOUD_ID is not applied)
+	tls          TLSOptions // TLS settings
+
+	retryOnStatus   []int                           // List of HTTP status codes to retry
+	maxRetries      int                             // Maximum number of retries
+	disableRetry    bool                            // Disable retries
+	retryBackoff    func(attempt int) time.Duration // Backoff duration function
+	requestTimeout  time.Duration                   // Timeout of a single request (0 means no timeout)
+	compression     bool                            // Compress request body
+	discoverOnStart bool                            // Discover cluster nodes when initializing the client
+	discoverEvery   time.Duration                   // Discover cluster nodes periodically (0 means disabled)
+	transport       http.RoundTripper               // Custom HTTP transport
+	logger          elastictransport.Logger         // Request / response logger
+	indexPrefix     string                          // Prefix added to every entity index name and pattern
+	bulk            BulkOptions                     // Bulk indexer default settings
+	retryOnConflict int                             // Number of retries of update operations on version conflict
+	indexTimeField  string                          // Entity field used to resolve the index of time partitioned tables
+	indexResolver   IndexResolver                   // Resolves the index names and patterns of the tables
+}
+
+// Create configuration with default settings
+func newElasticConfig() *elasticConfig {
+
+	// Retry backoff
+	retryBackoff := backoff.NewExponentialBackOff()
+
+	return &elasticConfig{
+		// Retry on 429 TooManyRequests statuses
+		retryOnStatus: []int{502, 503, 504, 429},
+
+		// Retry up to 5 attempts
+		maxRetries: 5,
+
+		// Configure the backoff function
+		retryBackoff: func(i int) time.Duration {
+			if i == 1 {
+				retryBackoff.Reset()
+			}
+			return retryBackoff.NextBackOff()
+		},
+
+		bulk: BulkOptions{FlushInterval: 2 * time.Second, MaxItemRetries: 3, ItemRetryBackoff: defaultItemRetryBackoff},
+
+		// Retry partial updates up to 3 times on version conflict
+		retryOnConflict: 3,
+	}
+}
+
+// Apply environment variables to the connection settings:
+// ELASTICSEARCH_HOSTS overrides the URI hosts, ELASTICSEARCH_API_KEY, ELASTICSEARCH_SERVICE_TOKEN and ELASTIC_CLOUD_ID
+// are used only when the value is not provided by the URI, ELASTIC_CLOUD_ID is not applied over an explicit host
+func (cfg *elasticConfig) applyEnvironment() {
+	if hosts := getHostsFromEnvironment(); len(hosts) > 0 {
+		cfg.hosts = hosts
+	}
+	if len(cfg.apiKey) == 0 {
+		cfg.apiKey = os.Getenv("ELASTICSEARCH_API_KEY")
+	}
+	if len(cfg.serviceToken) == 0 {
+		cfg.serviceToken = os.Getenv("ELASTICSEARCH_SERVICE_TOKEN")
+	}
+	if len(cfg.cloudID) == 0 && !cfg.explicitHost {
+		cfg.cloudID = os.Getenv("ELASTIC_CLOUD_ID")
+	}
+}
+
+// Get the endpoint description (for logging)
+func (cfg *elasticConfig) endpoint() string {
+	if len(cfg.cloudID) > 0 {
+		return fmt.Sprintf("cloud:%s", cfg.cloudID)
+	}
+	if len(cfg.hosts) > 0 {
+		return cfg.hosts[0]
+	}
+	return ""
+}
+
+// Build the elasticsearch client configuration
+func (cfg *elasticConfig) clientConfig() (elastic.Config, error) {
+
+	clientConfig := elastic.Config{
+		Addresses:             cfg.hosts,
+		Username:              cfg.username,
+		Password:              cfg.password,
+		APIKey:                cfg.apiKey,
+		ServiceToken:          cfg.serviceToken,
+		CloudID:               cfg.cloudID,
+		RetryOnStatus:         cfg.retryOnStatus,
+		MaxRetries:            cfg.maxRetries,
+		DisableRetry:          cfg.disableRetry,
+		RetryBackoff:          cfg.retryBackoff,
+		CompressRequestBody:   cfg.compression,
+		DiscoverNodesOnStart:  cfg.discoverOnStart,
+		DiscoverNodesInterval: cfg.discoverEvery,
+		Transport:             cfg.transport,
+		Logger:                cfg.logger,
+	}
+
+	// Elastic cloud ID and list of hosts are mutually exclusive
+	if len(cfg.cloudID) > 0 {
+		clientConfig.Addresses = nil
+	}
+
+	// Configure TLS
+	if err := cfg.tls.apply(&clientConfig); err != nil {
+		return clientConfig, err
+	}
+
+	// Configure request timeout
+	if cfg.requestTimeout > 0 {
+		next := clientConfig.Transport
+		if next == nil {
+			next = http.DefaultTransport
+		}
+		clientConfig.Transport = &timeoutTransport{next: next, timeout: cfg.requestTimeout}
+	}
+	return clientConfig, nil
+}
+
+// endregion
+
+// region Elastic store options ----------------------------------------------------------------------------------------
+
+// WithURI configures the connection settings from the datastore URI (see NewElasticStore for the URI format)
+func WithURI(URI string) Option {
+	return func(cfg *elasticConfig) error {
+		return parseElasticUrl(URI, cfg)
+	}
+}
+
+// WithEnvironment configures the connection settings from the environment variables:
+// ELASTICSEARCH_HOSTS, ELASTICSEARCH_API_KEY, ELASTICSEARCH_SERVICE_TOKEN and ELASTIC_CLOUD_ID
+func WithEnvironment() Option {
+	return func(cfg *elasticConfig) error {
+		cfg.applyEnvironment()
+		return nil
+	}
+}
+
+// WithHosts sets the list of elasticsearch nodes (full URLs, e.g. https://localhost:9200)
+func WithHosts(hosts ...string) Option {
+	return func(cfg *elasticConfig) error {
+		cfg.hosts = hosts
+		cfg.explicitHost = len(hosts) > 0
+		return nil
+	}
+}
+
+// WithBasicAuth sets the username and password for basic authentication
+func WithBasicAuth(username, password string) Option {
+	return func(cfg *elasticConfig) error {
+		cfg.username = username
+		cfg.password = password
+		return nil
+	}
+}
+
+// WithAPIKey sets the base64 encoded API key for authentication
+func WithAPIKey(apiKey string) Option {
+	return func(cfg *elasticConfig) error {
+		cfg.apiKey = apiKey
+		return nil
+	}
+}
+
+// WithServiceToken sets the service account bearer token for authentication
+func WithServiceToken(token string) Option {
+	return func(cfg *elasticConfig) error {
+		cfg.serviceToken = token
+		return nil
+	}
+}
+
+// WithCloudID sets the Elastic Cloud deployment ID (replaces the list of hosts)
+func WithCloudID(cloudID string) Option {
+	return func(cfg *elasticConfig) error {
+		cfg.cloudID = cloudID
+		return nil
+	}
+}
+
+// WithTLS sets the TLS settings
+func WithTLS(tls TLSOptions) Option {
+	return func(cfg *elasticConfig) error {
+		cfg.tls = tls
+		return nil
+	}
+}
+
+// WithMaxRetries sets the maximum number of retries of a failed request (default: 5)
+func WithMaxRetries(retries int) Option {
+	return func(cfg *elasticConfig) error {
+		if retries < 0 {
+			return fmt.Errorf("invalid number of retries: %d", retries)
+		}
+		cfg.maxRetries = retries
+		return nil
+	}
+}
+
+// WithRetryOnStatus sets the list of HTTP status codes to retry (default: 502, 503, 504, 429)
+func WithRetryOnStatus(statuses ...int) Option {
+	return func(cfg *elasticConfig) error {
+		cfg.retryOnStatus = statuses
+		return nil
+	}
+}
+
+// WithRetryBackoff sets the backoff function returning the duration to wait before the retry attempt (default: exponential backoff)
+func WithRetryBackoff(retryBackoff func(attempt int) time.Duration) Option {
+	return func(cfg *elasticConfig) error {
+		cfg.retryBackoff = retryBackoff
+		return nil
+	}
+}
+
+// WithDisableRetry disables the retry of failed requests
+func WithDisableRetry() Option {
+	return func(cfg *elasticConfig) error {
+		cfg.disableRetry = true
+		return nil
+	}
+}
+
+// WithRequestTimeout sets the timeout of a single HTTP request (including reading the response body)
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(cfg *elasticConfig) error {
+		cfg.requestTimeout = timeout
+		return nil
+	}
+}
+
+// WithCompression enables gzip compression of the request body
+func WithCompression(enabled bool) Option {
+	return func(cfg *elasticConfig) error {
+		cfg.compression = enabled
+		return nil
+	}
+}
+
+// WithNodeDiscovery enables cluster nodes discovery (sniffing) on start and / or periodically (interval 0 disables periodic discovery)
+func WithNodeDiscovery(onStart bool, interval time.Duration) Option {
+	return func(cfg *elasticConfig) error {
+		cfg.discoverOnStart = onStart
+		cfg.discoverEvery = interval
+		return nil
+	}
+}
+
+// WithTransport sets a custom HTTP transport
+func WithTransport(transport http.RoundTripper) Option {
+	return func(cfg *elasticConfig) error {
+		if transport == nil {
+			return errors.New("transport must not be nil")
+		}
+		cfg.transport = transport
+		return nil
+	}
+}
+
+// WithLogger sets the logger of the elasticsearch requests and responses (e.g. elastictransport.TextLogger)
+func WithLogger(logger elastictransport.Logger) Option {
+	return func(cfg *elasticConfig) error {
+		cfg.logger = logger
+		return nil
+	}
+}
+
+// WithIndexPrefix sets a prefix added to every index name and index pattern resolved from the entity table
+func WithIndexPrefix(prefix string) Option {
+	return func(cfg *elasticConfig) error {
+		cfg.indexPrefix = prefix
+		return nil
+	}
+}
+
+// WithBulkOptions sets the default settings of the bulk indexer (zero values keep the defaults)
+func WithBulkOptions(opts BulkOptions) Option {
+	return func(cfg *elasticConfig) error {
+		if opts.NumWorkers > 0 {
+			cfg.bulk.NumWorkers = opts.NumWorkers
+		}
+		if opts.FlushBytes > 0 {
+			cfg.bulk.FlushBytes = opts.FlushBytes
+		}
+		if opts.FlushInterval > 0 {
+			cfg.bulk.FlushInterval = opts.FlushInterval
+		}
+		if len(opts.Refresh) > 0 {
+			cfg.bulk.Refresh = opts.Refresh
+		}
+		if len(opts.Pipeline) > 0 {
+			cfg.bulk.Pipeline = opts.Pipeline
+		}
+		if opts.Timeout > 0 {
+			cfg.bulk.Timeout = opts.Timeout
+		}
+		if len(opts.Routing) > 0 {
+			cfg.bulk.Routing = opts.Routing
+		}
+		if opts.MaxItemRetries < 0 {
+			cfg.bulk.MaxItemRetries = 0
+		} else if opts.MaxItemRetries > 0 {
+			cfg.bulk.MaxItemRetries = opts.MaxItemRetries
+		}
+		if opts.ItemRetryBackoff > 0 {
+			cfg.bulk.ItemRetryBackoff = opts.ItemRetryBackoff
+		}
+		if opts.DeadLetter != nil {
+			cfg.bulk.DeadLetter = opts.DeadLetter
+		}
+		return nil
+	}
+}
+
+// WithRetryOnConflict sets the number of retries of update operations (Upsert, SetField, SetFields) on version conflict (default: 3)
+func WithRetryOnConflict(retries int) Option {
+	return func(cfg *elasticConfig) error {
+		if retries < 0 {
+			return fmt.Errorf("invalid number of retries on conflict: %d", retries)
+		}
+		cfg.retryOnConflict = retries
+		return nil
+	}
+}
+
+// WithIndexResolver sets custom index resolver for the index names and patterns of the tables (default: DefaultIndexResolver)
+func WithIndexResolver(resolver IndexResolver) Option {
+	return func(cfg *elasticConfig) error {
+		if resolver == nil {
+			return fmt.Errorf("index resolver is required")
+		}
+		cfg.indexResolver = resolver
+		return nil
+	}
+}
+
+// WithIndexTimeField sets the entity field (JSON name, e.g. createdOn) used to resolve the index of time partitioned tables
+// The field value can be epoch milliseconds or RFC3339 string, entities implementing IndexTimeProvider take precedence
+// When not set (or the field is empty) the index is resolved by the current time
+func WithIndexTimeField(field string) Option {
+	return func(cfg *elasticConfig) error {
+		cfg.indexTimeField = strings.TrimSpace(field)
+		return nil
+	}
+}
+
+// endregion
+
+// region Bulk operation options ---------------------------------------------------------------------------------------
+
+// BulkOption is a functional option to override the store bulk settings for a single bulk operation
+type BulkOption func(opts *BulkOptions)
+
+// BulkWorkers sets the number of concurrent workers
+func BulkWorkers(workers int) BulkOption {
+	return func(opts *BulkOptions) {
+		opts.NumWorkers = workers
+	}
+}
+
+// BulkFlushBytes sets the flush threshold in bytes
+func BulkFlushBytes(flushBytes int) BulkOption {
+	return func(opts *BulkOptions) {
+		opts.FlushBytes = flushBytes
+	}
+}
+
+// BulkFlushInterval sets the periodic flush interval
+func BulkFlushInterval(interval time.Duration) BulkOption {
+	return func(opts *BulkOptions) {
+		opts.FlushInterval = interval
+	}
+}
+
+// BulkRefresh sets the refresh policy: RefreshTrue, RefreshFalse or RefreshWaitFor
+func BulkRefresh(refresh string) BulkOption {
+	return func(opts *BulkOptions) {
+		opts.Refresh = refresh
+	}
+}
+
+// BulkPipeline sets the ingest pipeline to pre-process the documents
+func BulkPipeline(pipeline string) BulkOption {
+	return func(opts *BulkOptions) {
+		opts.Pipeline = pipeline
+	}
+}
+
+// BulkTimeout sets the timeout of a single bulk request on the server side
+func BulkTimeout(timeout time.Duration) BulkOption {
+	return func(opts *BulkOptions) {
+		opts.Timeout = timeout
+	}
+}
+
+// BulkRouting sets the custom routing value of the documents
+func BulkRouting(routing string) BulkOption {
+	return func(opts *BulkOptions) {
+		opts.Routing = routing
+	}
+}
+
+// BulkRetry sets the number of retries of items rejected by elasticsearch and the initial backoff (0 retries disables retries)
+func BulkRetry(maxRetries int, backoff time.Duration) BulkOption {
+	return func(opts *BulkOptions) {
+		opts.MaxItemRetries = maxRetries
+		opts.ItemRetryBackoff = backoff
+	}
+}
+
+// BulkDeadLetter sets the sink for the items which failed permanently
+func BulkDeadLetter(sink DeadLetterSink) BulkOption {
+	return func(opts *BulkOptions) {
+		opts.DeadLetter = sink
+	}
+}
+
+// endregion
+
+// region Request timeout transport ------------------------------------------------------------------------------------
+
+// timeoutTransport applies timeout on every request, the timeout covers reading the response body
+type timeoutTransport struct {
+	next    http.RoundTripper
+	timeout time.Duration
+}
+
+// RoundTrip executes a single HTTP transaction bound to the timeout
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	res, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	res.Body = &cancelOnCloseBody{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+// cancelOnCloseBody releases the request timeout context when the response body is closed
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close the response body and release the context
+func (b *cancelOnCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// endregion
@@ -3,32 +3,118 @@ package elasticsearch
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"github.com/elastic/go-elasticsearch/v8/typedapi/core/search"
 	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/expandwildcard"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/totalhitsrelation"
 	. "github.com/go-yaaf/yaaf-common/database"
 	. "github.com/go-yaaf/yaaf-common/entity"
-	"github.com/go-yaaf/yaaf-common/utils"
 	"io"
+	"iter"
 	"strings"
+	"time"
 )
 
+// region Elasticsearch query interface -------------------------------------------------------------------------------
+
+// IElasticQuery extends the IQuery interface with elasticsearch specific operations
+// The query returned by ElasticStore.Query implements this interface, use ElasticStore.ElasticQuery or type assertion to get it
+type IElasticQuery interface {
+	IQuery
+
+	// ByQuery sets the options of the server side delete / update by query operations
+	ByQuery(opts ByQueryOptions) IElasticQuery
+
+	// DeleteByQuery deletes all the documents meeting the criteria and returns the detailed result
+	DeleteByQuery(keys ...string) (*ByQueryResult, error)
+
+	// UpdateByQuery executes the script on all the documents meeting the criteria and returns the detailed result
+	UpdateByQuery(script Script, keys ...string) (*ByQueryResult, error)
+
+	// UpdateWithScript executes the script on all the documents meeting the criteria and returns the number of updated documents
+	UpdateWithScript(script Script, keys ...string) (int64, error)
+
+	// KeepAlive sets how long the point in time of the cursor is kept between pages (default: 1 minute)
+	KeepAlive(keepAlive time.Duration) IElasticQuery
+
+	// FindAfter executes the query with cursor based pagination (point in time and search_after), use empty cursor for
+	// the first page. Returns the page and the cursor of the next page (empty when there are no more results)
+	FindAfter(cursor string, keys ...string) ([]Entity, string, error)
+
+	// Stream iterates over all the documents meeting the criteria with bounded memory (point in time and search_after)
+	Stream(ctx context.Context, keys ...string) iter.Seq2[Entity, error]
+
+	// TotalHits sets how the total number of hits of Find is calculated (default: TotalHitsCount)
+	TotalHits(mode TotalHitsMode) IElasticQuery
+
+	// FindWithTotal executes the query based on the criteria, order and pagination and returns the total hits details
+	FindWithTotal(keys ...string) ([]Entity, TotalHits, error)
+
+	// MatchText adds full-text queries (see TextQuery), all of them should match (AND)
+	MatchText(queries ...TextQuery) IElasticQuery
+
+	// MatchAnyText adds list of full-text queries (see TextQuery), any of them should match (OR)
+	MatchAnyText(queries ...TextQuery) IElasticQuery
+
+	// Should adds optional scored clauses (see TextQuery and ScoredFilter), matching documents score higher
+	Should(queries ...TextQuery) IElasticQuery
+
+	// ScoreWith adds functions to modify the relevance score of the hits (see RecencyBoost and FieldValueFactor)
+	ScoreWith(functions ...ScoreFunction) IElasticQuery
+
+	// FindScored executes the query based on the criteria, order and pagination and returns the entities with their score
+	FindScored(keys ...string) ([]ScoredEntity, TotalHits, error)
+}
+
+// TotalHitsMode defines how the total number of hits of Find is calculated
+type TotalHitsMode int
+
+const (
+	TotalHitsCount TotalHitsMode = 0  // Exact total by a separate count request (default)
+	TotalHitsExact TotalHitsMode = -1 // Exact total from the search response (track_total_hits: true)
+	TotalHitsNone  TotalHitsMode = -2 // No total, saves the counting cost (track_total_hits: false)
+)
+
+// TotalHitsUpTo returns bounded total mode, the total from the search response is exact up to the limit
+// (track_total_hits: limit), beyond the limit the total is the limit and TotalHits.MoreThan is set
+func TotalHitsUpTo(limit int) TotalHitsMode {
+	if limit <= 0 {
+		return TotalHitsNone
+	}
+	return TotalHitsMode(limit)
+}
+
+// TotalHits holds the total number of hits of the query
+type TotalHits struct {
+	Value    int64 // Number of hits (lower bound if MoreThan is set, 0 for TotalHitsNone)
+	MoreThan bool  // Set when the total is bounded and there are more hits than Value
+}
+
+// endregion
+
 // region queryBuilder internal structure ------------------------------------------------------------------------------
 
 type elasticDatastoreQuery struct {
-	dbs        *ElasticStore            // A reference to the underlying IDatastore
-	factory    EntityFactory            // The entity factory method
-	allFilters [][]QueryFilter          // List of lists of AND filters
-	anyFilters [][]QueryFilter          // List of lists of OR filters
-	ascOrders  []any                    // List of fields for ASC order
-	descOrders []any                    // List of fields for DESC order
-	callbacks  []func(in Entity) Entity // List of entity transformation callback functions
-	page       int                      // Page number (for pagination)
-	limit      int                      // Page size: how many results in a page (for pagination)
-	rangeField string                   // Field name for range filter (must be timestamp field)
-	lastQuery  string                   // Holds the native query DSL of the last query (for debugging)
-	rangeFrom  Timestamp                // Start timestamp for range filter
-	rangeTo    Timestamp                // End timestamp for range filter
+	dbs            *ElasticStore            // A reference to the underlying IDatastore
+	factory        EntityFactory            // The entity factory method
+	allFilters     [][]QueryFilter          // List of lists of AND filters
+	anyFilters     [][]QueryFilter          // List of lists of OR filters
+	ascOrders      []any                    // List of fields for ASC order
+	descOrders     []any                    // List of fields for DESC order
+	callbacks      []func(in Entity) Entity // List of entity transformation callback functions
+	page           int                      // Page number (for pagination)
+	limit          int                      // Page size: how many results in a page (for pagination)
+	limitSet       bool                     // True if the page size was set by Limit (otherwise FindAfter and Stream use their own default)
+	rangeField     string                   // Field name for range filter (must be timestamp field)
+	lastQuery      string                   // Holds the native query DSL of the last query (for debugging)
+	rangeFrom      Timestamp                // Start timestamp for range filter
+	rangeTo        Timestamp                // End timestamp for range filter
+	byQuery        ByQueryOptions           // Options of the delete / update by query operations
+	keepAlive      time.Duration            // Point in time keep alive of the cursor based pagination
+	totalHits      TotalHitsMode            // How the total number of hits of Find is calculated
+	allTexts       []TextQuery              // List of AND full-text queries
+	anyTexts       [][]TextQuery            // List of lists of OR full-text queries
+	shouldTexts    []TextQuery              // List of optional scored queries
+	scoreFunctions []ScoreFunction          // List of functions modifying the relevance score
 }
 
 // endregion
@@ -104,6 +190,7 @@ func (s *elasticDatastoreQuery) Sort(sort string) IQuery {
 // Limit Set page size limit (for pagination)
 func (s *elasticDatastoreQuery) Limit(limit int) IQuery {
 	s.limit = limit
+	s.limitSet = true
 	return s
 }
 
@@ -137,14 +224,42 @@ func (s *elasticDatastoreQuery) List(entityIDs []string, keys ...string) (out []
 
 // Find Execute query based on the criteria, order and pagination
 // On each record, after the marshaling the result shall be transformed via the query callback chain
+// The total is calculated according to the total hits mode (see TotalHits)
 func (s *elasticDatastoreQuery) Find(keys ...string) ([]Entity, int64, error) {
+	result, total, err := s.FindWithTotal(keys...)
+	return result, total.Value, err
+}
+
+// TotalHits sets how the total number of hits of Find is calculated: separate count request (default), exact or bounded
+// total from the same search response or no total at all
+func (s *elasticDatastoreQuery) TotalHits(mode TotalHitsMode) IElasticQuery {
+	s.totalHits = mode
+	return s
+}
+
+// FindWithTotal executes the query based on the criteria, order and pagination and returns the total hits details
+// Except for the TotalHitsCount mode, the hits and the total are returned by a single search request
+func (s *elasticDatastoreQuery) FindWithTotal(keys ...string) ([]Entity, TotalHits, error) {
+	list, total, err := s.find(false, keys...)
+	if err != nil {
+		return nil, total, err
+	}
+	result := make([]Entity, 0, len(list))
+	for _, se := range list {
+		result = append(result, se.Entity)
+	}
+	return result, total, nil
+}
 
+// Execute the search based on the criteria, order and pagination, the scores are tracked when sorted by field if requested
+func (s *elasticDatastoreQuery) find(trackScores bool, keys ...string) ([]ScoredEntity, TotalHits, error) {
+
+	total := TotalHits{}
 	query, err := s.buildQuery()
 	if err != nil {
-		return nil, 0, err
+		return nil, total, err
 	}
 
-	pattern := indexPattern(s.factory, keys...)
 	size := s.limit
 
 	page := s.page - 1
@@ -155,38 +270,52 @@ func (s *elasticDatastoreQuery) Find(keys ...string) ([]Entity, int64, error) {
 
 	req := &search.Request{Size: &size, From: &from, Query: query}
 	req.Sort = s.buildSort()
+	if trackScores && len(req.Sort) > 0 {
+		req.TrackScores = &trackScores
+	}
 
-	// First, calculate document count (don't use TrackTotalHits)
-	totalHits, er := s.Count(keys...)
-	if er != nil {
-		return nil, 0, er
+	switch {
+	case s.totalHits == TotalHitsCount:
+		// First, calculate document count (don't use TrackTotalHits)
+		if totalHits, er := s.Count(keys...); er != nil {
+			return nil, total, er
+		} else {
+			total.Value = totalHits
+		}
+		req.TrackTotalHits = false
+	case s.totalHits == TotalHitsExact:
+		req.TrackTotalHits = true
+	case s.totalHits > 0:
+		req.TrackTotalHits = int(s.totalHits)
+	default:
+		req.TrackTotalHits = false
 	}
 
-	searchObject := s.dbs.tClient.Search().Index(pattern).
-		ExpandWildcards(expandwildcard.All).
-		AllowNoIndices(true).
-		Request(req)
-	//Sort(s.buildSort())
+	searchObject := s.search(keys...).Request(req)
 
 	// Log before executing the request
 	s.logLastQuery(searchObject)
-	res, err := searchObject.Do(context.Background())
+	res, err := searchObject.Do(s.dbs.getContext())
 	if err != nil {
-		return nil, 0, ElasticError(err)
+		return nil, total, ElasticError(err)
 	}
 
-	result := make([]Entity, 0)
+	result := make([]ScoredEntity, 0)
 	for _, hit := range res.Hits.Hits {
 		entity := s.factory()
 		if jer := json.Unmarshal(hit.Source_, &entity); jer == nil {
 			transformed := s.processCallbacks(entity)
 			if transformed != nil {
-				result = append(result, transformed)
+				result = append(result, ScoredEntity{Entity: transformed, Score: float64(hit.Score_)})
 			}
 		}
 	}
 
-	return result, totalHits, nil
+	if s.totalHits != TotalHitsCount && s.totalHits != TotalHitsNone && res.Hits.Total != nil {
+		total.Value = res.Hits.Total.Value
+		total.MoreThan = res.Hits.Total.Relation == totalhitsrelation.Gte
+	}
+	return result, total, nil
 }
 
 // Select is similar to find but with ability to retrieve specific fields
@@ -234,7 +363,7 @@ func (s *elasticDatastoreQuery) FindSingle(keys ...string) (entity Entity, err e
 		return nil, fe
 	} else {
 		if len(list) == 0 {
-			return nil, fmt.Errorf("not found")
+			return nil, notFoundError("not found")
 		} else {
 			return list[0], nil
 		}
@@ -273,21 +402,15 @@ func (s *elasticDatastoreQuery) GetIDs(keys ...string) (out []string, err error)
 // region QueryBuilder Delete and Update Execution Methods -------------------------------------------------------------
 
 // Delete Execute delete command based on the where criteria
+// The documents are deleted on the server side (delete by query) in all the indices of the pattern, when aborted on
+// a version conflict the number of documents deleted before the conflict is returned with the error
 func (s *elasticDatastoreQuery) Delete(keys ...string) (total int64, err error) {
-	deleteIds := make([]string, 0)
-
-	if list, _, fe := s.Find(keys...); fe != nil {
+	if result, fe := s.DeleteByQuery(keys...); result == nil {
 		return 0, fe
+	} else if fe != nil {
+		return result.Deleted, fe
 	} else {
-		for _, ent := range list {
-			deleteIds = append(deleteIds, ent.ID())
-		}
-	}
-
-	if affected, fe := s.dbs.BulkDelete(s.factory, deleteIds, keys...); fe != nil {
-		return 0, fe
-	} else {
-		return affected, nil
+		return result.Deleted, result.Err()
 	}
 }
 
@@ -299,34 +422,21 @@ func (s *elasticDatastoreQuery) SetField(field string, value any, keys ...string
 }
 
 // SetFields Update multiple fields of all the documents meeting the criteria in a single transaction
+// The documents are updated on the server side (update by query) in all the indices of the pattern
 func (s *elasticDatastoreQuery) SetFields(fields map[string]any, keys ...string) (total int64, err error) {
+	return s.UpdateWithScript(setFieldsScript(fields), keys...)
+}
 
-	changeList := make([]Entity, 0)
-
-	list, _, fe := s.Find(keys...)
-	if fe != nil {
-		return 0, fe
-	}
-
-	for _, entity := range list {
-		raw, er := utils.JsonUtils().ToJson(entity)
-		if er != nil {
-			continue
-		}
-
-		for f, v := range fields {
-			raw[f] = v
-		}
-
-		if changed, _ := utils.JsonUtils().FromJson(s.factory, raw); changed != nil {
-			changeList = append(changeList, changed)
-		}
-	}
-
-	if result, err := s.dbs.BulkUpdate(changeList); fe != nil {
+// UpdateWithScript executes the script on all the documents meeting the criteria (in all the indices of the pattern)
+// using a single update by query request, returns the number of updated documents (when aborted on a version conflict
+// the number of documents updated before the conflict is returned with the error)
+func (s *elasticDatastoreQuery) UpdateWithScript(script Script, keys ...string) (int64, error) {
+	if result, err := s.UpdateByQuery(script, keys...); result == nil {
 		return 0, err
+	} else if err != nil {
+		return result.Updated, err
 	} else {
-		return result, nil
+		return result.Updated, result.Err()
 	}
 }
 
@@ -334,6 +444,29 @@ func (s *elasticDatastoreQuery) SetFields(fields map[string]any, keys ...string)
 
 // region QueryBuilder Internal Methods --------------------------------------------------------------------------------
 
+// Create search request over the indices of the query (see searchIndices), missing indices are ignored
+func (s *elasticDatastoreQuery) search(keys ...string) *search.Search {
+	return s.dbs.tClient.Search().
+		Index(strings.Join(s.searchIndices(keys...), ",")).
+		ExpandWildcards(expandwildcard.All).
+		AllowNoIndices(true).
+		IgnoreUnavailable(true)
+}
+
+// Resolve the indices to search: when the query time range is on the configured index time field (see WithIndexTimeField)
+// the search is narrowed to the concrete indices overlapping the range, otherwise the table index pattern is used
+// The indices may be concrete names (narrowed range or table without templates), missing indices must be ignored
+func (s *elasticDatastoreQuery) searchIndices(keys ...string) []string {
+	table := s.factory().TABLE()
+	if len(s.rangeField) > 0 && s.rangeField == s.dbs.rangeIndexField() && s.rangeFrom > 0 && s.rangeTo >= s.rangeFrom {
+		from, to := time.UnixMilli(int64(s.rangeFrom)), time.UnixMilli(int64(s.rangeTo))
+		if indices, ok := s.dbs.rangeIndices(table, from, to, keys...); ok {
+			return indices
+		}
+	}
+	return []string{s.dbs.indexPatternFromTable(table, keys...)}
+}
+
 // Transform the entity through the chain of callbacks
 func (s *elasticDatastoreQuery) processCallbacks(in Entity) (out Entity) {
 	if len(s.callbacks) == 0 {
@@ -368,7 +501,7 @@ func (s *elasticDatastoreQuery) String() string {
 
 // Log the last query
 func (s *elasticDatastoreQuery) logLastQuery(so *search.Search) {
-	req, err := so.HttpRequest(context.Background())
+	req, err := so.HttpRequest(s.dbs.getContext())
 	if err != nil {
 		return
 	}
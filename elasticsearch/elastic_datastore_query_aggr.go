@@ -1,12 +1,10 @@
 package elasticsearch
 
 import (
-	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
-	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/expandwildcard"
 	"github.com/go-yaaf/yaaf-common/database"
 	"strings"
 	"time"
@@ -31,8 +29,7 @@ func (s *elasticDatastoreQuery) Count(keys ...string) (int64, error) {
 		return 0, err
 	}
 
-	pattern := indexPattern(s.factory, keys...)
-	fmt.Println(pattern)
+	indices := s.searchIndices(keys...)
 
 	queryStr := ""
 
@@ -45,9 +42,10 @@ func (s *elasticDatastoreQuery) Count(keys ...string) (int64, error) {
 	}
 
 	res, err := s.dbs.esClient.Count(
-		s.dbs.esClient.Count.WithContext(context.Background()),
-		s.dbs.esClient.Count.WithIndex(pattern),
+		s.dbs.esClient.Count.WithContext(s.dbs.getContext()),
+		s.dbs.esClient.Count.WithIndex(indices...),
 		s.dbs.esClient.Count.WithExpandWildcards("all"),
+		s.dbs.esClient.Count.WithIgnoreUnavailable(true),
 		s.dbs.esClient.Count.WithBody(strings.NewReader(queryStr)),
 	)
 	if err != nil {
@@ -58,8 +56,8 @@ func (s *elasticDatastoreQuery) Count(keys ...string) (int64, error) {
 		_ = res.Body.Close()
 	}()
 
-	if res.IsError() {
-		return 0, ElasticError(fmt.Errorf("%s: %s", res.Status(), res.String()))
+	if er := responseError(res); er != nil {
+		return 0, er
 	}
 
 	var cr countResponse
@@ -79,7 +77,6 @@ func (s *elasticDatastoreQuery) Aggregation(field string, function database.AggF
 		return 0, err
 	}
 
-	pattern := indexPattern(s.factory, keys...)
 	size := 0
 
 	queryAggregations := *types.NewAggregations()
@@ -114,13 +111,12 @@ func (s *elasticDatastoreQuery) Aggregation(field string, function database.AggF
 
 	req := &search.Request{Size: &size, Query: query, Aggregations: map[string]types.Aggregations{"aggs": queryAggregations}}
 
-	searchObject := s.dbs.tClient.Search().Index(pattern).
-		ExpandWildcards(expandwildcard.All).
+	searchObject := s.search(keys...).
 		Request(req)
 
 	// Log before executing the request
 	s.logLastQuery(searchObject)
-	res, err := searchObject.Do(context.Background())
+	res, err := searchObject.Do(s.dbs.getContext())
 	if err != nil {
 		return 0, ElasticError(err)
 	}
@@ -162,7 +158,6 @@ func (s *elasticDatastoreQuery) GroupAggregation(field string, function database
 		return result, total, err
 	}
 
-	pattern := indexPattern(s.factory, keys...)
 	size := 0
 
 	queryAggregations := *types.NewAggregations()
@@ -175,13 +170,12 @@ func (s *elasticDatastoreQuery) GroupAggregation(field string, function database
 
 	req := &search.Request{Size: &size, Query: query, Aggregations: map[string]types.Aggregations{"aggs": queryAggregations}}
 
-	searchObject := s.dbs.tClient.Search().Index(pattern).
-		ExpandWildcards(expandwildcard.All).
+	searchObject := s.search(keys...).
 		Request(req)
 
 	// Log before executing the request
 	s.logLastQuery(searchObject)
-	res, err := searchObject.Do(context.Background())
+	res, err := searchObject.Do(s.dbs.getContext())
 	if err != nil {
 		return result, total, ElasticError(err)
 	}
@@ -205,7 +199,6 @@ func (s *elasticDatastoreQuery) Histogram(field string, function database.AggFun
 		return result, 0, err
 	}
 
-	pattern := indexPattern(s.factory, keys...)
 	size := 0
 
 	queryAggregations, err := s.getIntervalAggregation(timeField, interval)
@@ -235,10 +228,10 @@ func (s *elasticDatastoreQuery) Histogram(field string, function database.AggFun
 	}
 
 	req := &search.Request{Size: &size, Query: query, Aggregations: map[string]types.Aggregations{"0": *rootAggregation}}
-	searchObject := s.dbs.tClient.Search().Index(pattern).ExpandWildcards(expandwildcard.All).Request(req)
+	searchObject := s.search(keys...).Request(req)
 	s.logLastQuery(searchObject)
 
-	res, err := searchObject.Do(context.Background())
+	res, err := searchObject.Do(s.dbs.getContext())
 	if err != nil {
 		return result, total, ElasticError(err)
 	}
@@ -258,7 +251,6 @@ func (s *elasticDatastoreQuery) Histogram2D(field string, function database.AggF
 		return result, 0, err
 	}
 
-	pattern := indexPattern(s.factory, keys...)
 	size := 0
 
 	queryAggregations, err := s.getIntervalAggregation(timeField, interval)
@@ -289,10 +281,10 @@ func (s *elasticDatastoreQuery) Histogram2D(field string, function database.AggF
 	}
 
 	req := &search.Request{Size: &size, Query: query, Aggregations: map[string]types.Aggregations{"0": *rootAggregation}}
-	searchObject := s.dbs.tClient.Search().Index(pattern).ExpandWildcards(expandwildcard.All).Request(req)
+	searchObject := s.search(keys...).Request(req)
 	s.logLastQuery(searchObject)
 
-	res, err := searchObject.Do(context.Background())
+	res, err := searchObject.Do(s.dbs.getContext())
 	if err != nil {
 		return result, total, ElasticError(err)
 	}
@@ -301,10 +293,10 @@ func (s *elasticDatastoreQuery) Histogram2D(field string, function database.AggF
 	//s.addGroupAggregation(queryAggregations, field, function, dim)
 	//
 	//req := &search.Request{Size: &size, Query: query, Aggregations: map[string]types.Aggregations{"0": *queryAggregations}}
-	//searchObject := s.dbs.tClient.Search().Index(pattern).ExpandWildcards(expandwildcard.All).Request(req)
+	//searchObject := s.search(keys...).Request(req)
 	//s.logLastQuery(searchObject)
 	//
-	//res, err := searchObject.Do(context.Background())
+	//res, err := searchObject.Do(s.dbs.getContext())
 	//if err != nil {
 	//	return result, total, ElasticError(err)
 	//}
@@ -321,7 +313,6 @@ func (s *elasticDatastoreQuery) NestedHistogram2D(field string, function databas
 		return result, 0, err
 	}
 
-	pattern := indexPattern(s.factory, keys...)
 	size := 0
 
 	queryAggregations := *types.NewAggregations()
@@ -353,13 +344,12 @@ func (s *elasticDatastoreQuery) NestedHistogram2D(field string, function databas
 
 	req := &search.Request{Size: &size, Query: query, Aggregations: map[string]types.Aggregations{"0": queryAggregations}}
 
-	searchObject := s.dbs.tClient.Search().Index(pattern).
-		ExpandWildcards(expandwildcard.All).
+	searchObject := s.search(keys...).
 		Request(req)
 
 	// Log before executing the request
 	s.logLastQuery(searchObject)
-	res, err := searchObject.Do(context.Background())
+	res, err := searchObject.Do(s.dbs.getContext())
 	if err != nil {
 		return result, total, ElasticError(err)
 	}
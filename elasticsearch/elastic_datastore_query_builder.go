@@ -34,13 +34,15 @@ func (s *elasticDatastoreQuery) buildSort() (result []types.SortCombinations) {
 // Build the typedAPI query object
 func (s *elasticDatastoreQuery) buildQuery() (*types.Query, error) {
 
+	textQueries := s.buildTextQueries()
+
 	// If there is a single filter, no need for bool query
-	if qf, _ := s.getSingleFilter(); qf != nil && len(s.rangeField) == 0 {
+	if qf, _ := s.getSingleFilter(); qf != nil && len(s.rangeField) == 0 && len(textQueries) == 0 && len(s.shouldTexts) == 0 {
 		query, inc := queryTerms[qf.GetOperator()](qf)
 		if inc {
-			return query, nil
+			return s.buildScoreQuery(query)
 		} else {
-			return &types.Query{Bool: &types.BoolQuery{MustNot: []types.Query{*query}}}, nil
+			return s.buildScoreQuery(&types.Query{Bool: &types.BoolQuery{MustNot: []types.Query{*query}}})
 		}
 	}
 
@@ -101,12 +103,20 @@ func (s *elasticDatastoreQuery) buildQuery() (*types.Query, error) {
 		}
 	}
 
+	// Full-text queries are scored by relevance, should queries are optional (only affect the score)
+	rootQuery.Must = textQueries
 	rootQuery.Filter = rootFilters
 	rootQuery.MustNot = notQueries
+	if len(s.shouldTexts) > 0 {
+		for _, q := range s.shouldTexts {
+			rootQuery.Should = append(rootQuery.Should, q.query())
+		}
+		rootQuery.MinimumShouldMatch = 0
+	}
 	result := &types.Query{
 		Bool: rootQuery,
 	}
-	return result, nil
+	return s.buildScoreQuery(result)
 }
 
 // Get filter only if there is one single filter
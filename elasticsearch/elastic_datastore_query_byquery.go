@@ -0,0 +1,258 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/conflicts"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/expandwildcard"
+)
+
+// region By query operations definitions ------------------------------------------------------------------------------
+
+// ByQueryOptions holds the settings of the server side delete / update by query operations
+type ByQueryOptions struct {
+	ProceedOnConflicts bool    // Count version conflicts instead of aborting the operation (conflicts=proceed)
+	Slices             int     // Number of slices to run in parallel (0: no slicing, -1: auto)
+	RequestsPerSecond  float64 // Throttle the operation to the number of requests per second (0: unlimited)
+	Async              bool    // Run the operation as a background task and return the task ID (wait_for_completion=false)
+	Refresh            bool    // Refresh the affected indices when the operation completes
+	MaxDocs            int64   // Maximum number of documents to process (0: all)
+}
+
+// ByQueryResult holds the result of the server side delete / update by query operations
+type ByQueryResult struct {
+	TaskID           string  // The task ID (only in async mode, use ElasticStore.GetTask to track it)
+	Completed        bool    // True if the operation is completed
+	Total            int64   // Number of documents processed
+	Deleted          int64   // Number of deleted documents
+	Updated          int64   // Number of updated documents
+	Noops            int64   // Number of documents ignored by the script (ctx.op = 'noop')
+	VersionConflicts int64   // Number of version conflicts
+	Failures         []error // List of failures (classified as *StoreError)
+}
+
+// Err returns the first failure or nil if there are no failures
+func (r *ByQueryResult) Err() error {
+	if len(r.Failures) > 0 {
+		return r.Failures[0]
+	}
+	return nil
+}
+
+// Counters of the by query response or task status
+type byQueryStatus struct {
+	Total            *int64                           `json:"total,omitempty"`
+	Deleted          *int64                           `json:"deleted,omitempty"`
+	Updated          *int64                           `json:"updated,omitempty"`
+	Noops            *int64                           `json:"noops,omitempty"`
+	VersionConflicts *int64                           `json:"version_conflicts,omitempty"`
+	Failures         []types.BulkIndexByScrollFailure `json:"failures,omitempty"`
+	Task             types.TaskId                     `json:"task,omitempty"`
+}
+
+// Convert the counters to result
+func (s byQueryStatus) result() *ByQueryResult {
+	value := func(v *int64) int64 {
+		if v == nil {
+			return 0
+		}
+		return *v
+	}
+
+	result := &ByQueryResult{
+		Completed:        s.Task == nil,
+		Total:            value(s.Total),
+		Deleted:          value(s.Deleted),
+		Updated:          value(s.Updated),
+		Noops:            value(s.Noops),
+		VersionConflicts: value(s.VersionConflicts),
+		Failures:         make([]error, 0, len(s.Failures)),
+	}
+	if s.Task != nil {
+		result.TaskID = fmt.Sprint(s.Task)
+	}
+	for _, f := range s.Failures {
+		result.Failures = append(result.Failures, byQueryFailureError(f))
+	}
+	return result
+}
+
+// endregion
+
+// region By query execution methods -----------------------------------------------------------------------------------
+
+// ByQuery sets the options of the server side delete / update by query operations
+func (s *elasticDatastoreQuery) ByQuery(opts ByQueryOptions) IElasticQuery {
+	s.byQuery = opts
+	return s
+}
+
+// DeleteByQuery deletes all the documents meeting the criteria (in all the indices of the pattern) on the server side
+// In async mode the result includes only the task ID. When the operation is aborted on a version conflict (see
+// ByQueryOptions.ProceedOnConflicts) the result holds the changes applied before the conflict along with the error
+func (s *elasticDatastoreQuery) DeleteByQuery(keys ...string) (*ByQueryResult, error) {
+
+	query, err := s.buildQuery()
+	if err != nil {
+		return nil, err
+	}
+
+	pattern := s.dbs.indexPattern(s.factory, keys...)
+	dbq := s.dbs.tClient.DeleteByQuery(pattern).
+		ExpandWildcards(expandwildcard.All).
+		AllowNoIndices(true).
+		IgnoreUnavailable(true).
+		Query(query)
+
+	opts := s.byQuery
+	if opts.ProceedOnConflicts {
+		dbq.Conflicts(conflicts.Proceed)
+	}
+	if opts.Slices != 0 {
+		dbq.Slices(slicesParam(opts.Slices))
+	}
+	if opts.RequestsPerSecond > 0 {
+		dbq.RequestsPerSecond(strconv.FormatFloat(opts.RequestsPerSecond, 'f', -1, 64))
+	}
+	if opts.Async {
+		dbq.WaitForCompletion(false)
+	}
+	if opts.Refresh {
+		dbq.Refresh(true)
+	}
+	if opts.MaxDocs > 0 {
+		dbq.MaxDocs(opts.MaxDocs)
+	}
+
+	return byQueryResponse(dbq.Perform(s.dbs.getContext()))
+}
+
+// UpdateByQuery executes the script on all the documents meeting the criteria (in all the indices of the pattern)
+// on the server side, in async mode the result includes only the task ID. When the operation is aborted on a version
+// conflict the result holds the changes applied before the conflict along with the error
+func (s *elasticDatastoreQuery) UpdateByQuery(script Script, keys ...string) (*ByQueryResult, error) {
+
+	inline, err := script.inlineScript()
+	if err != nil {
+		return nil, err
+	}
+
+	query, err := s.buildQuery()
+	if err != nil {
+		return nil, err
+	}
+
+	pattern := s.dbs.indexPattern(s.factory, keys...)
+	ubq := s.dbs.tClient.UpdateByQuery(pattern).
+		ExpandWildcards(expandwildcard.All).
+		AllowNoIndices(true).
+		IgnoreUnavailable(true).
+		Query(query).
+		Script(inline)
+
+	opts := s.byQuery
+	if opts.ProceedOnConflicts {
+		ubq.Conflicts(conflicts.Proceed)
+	}
+	if opts.Slices != 0 {
+		ubq.Slices(slicesParam(opts.Slices))
+	}
+	if opts.RequestsPerSecond > 0 {
+		ubq.RequestsPerSecond(strconv.FormatFloat(opts.RequestsPerSecond, 'f', -1, 64))
+	}
+	if opts.Async {
+		ubq.WaitForCompletion(false)
+	}
+	if opts.Refresh {
+		ubq.Refresh(true)
+	}
+	if opts.MaxDocs > 0 {
+		ubq.MaxDocs(opts.MaxDocs)
+	}
+
+	return byQueryResponse(ubq.Perform(s.dbs.getContext()))
+}
+
+// GetTask gets the status of a delete / update by query operation executed in async mode
+func (dbs *ElasticStore) GetTask(taskID string) (*ByQueryResult, error) {
+
+	res, err := dbs.tClient.Tasks.Get(taskID).Do(dbs.getContext())
+	if err != nil {
+		return nil, ElasticError(err)
+	}
+	if res.Error != nil {
+		ee := types.NewElasticsearchError()
+		ee.ErrorCause = *res.Error
+		return nil, ElasticError(ee)
+	}
+
+	// The final response is available when the task is completed, otherwise use the running status
+	status := byQueryStatus{}
+	data := res.Task.Status
+	if res.Completed && len(res.Response) > 0 {
+		data = res.Response
+	}
+	if len(data) > 0 {
+		if err = json.Unmarshal(data, &status); err != nil {
+			return nil, err
+		}
+	}
+
+	result := status.result()
+	result.TaskID = taskID
+	result.Completed = res.Completed
+	return result, nil
+}
+
+// Read the response of delete / update by query operation, the operation aborted on a version conflict returns
+// status 409 with the counters of the changes applied before the conflict, the result is returned with the error
+func byQueryResponse(res *http.Response, err error) (*ByQueryResult, error) {
+	if err != nil {
+		return nil, ElasticError(err)
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, ElasticError(err)
+	}
+
+	if res.StatusCode < 299 || res.StatusCode == http.StatusConflict {
+		status := byQueryStatus{}
+		if err = json.Unmarshal(data, &status); err != nil {
+			return nil, err
+		}
+		result := status.result()
+		if res.StatusCode < 299 {
+			return result, nil
+		}
+		if err = result.Err(); err == nil {
+			err = &StoreError{Kind: ErrVersionConflict, Status: res.StatusCode, Reason: "by query operation aborted on version conflict"}
+		}
+		return result, err
+	}
+
+	ee := types.NewElasticsearchError()
+	if err = json.Unmarshal(data, ee); err != nil {
+		return nil, &StoreError{Kind: classifyError(res.StatusCode, "", ""), Status: res.StatusCode, Reason: string(data)}
+	}
+	if ee.Status == 0 {
+		ee.Status = res.StatusCode
+	}
+	return nil, ElasticError(ee)
+}
+
+// Convert number of slices to parameter (-1 is auto)
+func slicesParam(slices int) string {
+	if slices < 0 {
+		return "auto"
+	}
+	return strconv.Itoa(slices)
+}
+
+// endregion
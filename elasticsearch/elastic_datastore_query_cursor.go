@@ -0,0 +1,239 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/core/search"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/expandwildcard"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/sortorder"
+
+	. "github.com/go-yaaf/yaaf-common/entity"
+)
+
+// region Cursor definitions -------------------------------------------------------------------------------------------
+
+const (
+	defaultCursorKeepAlive = time.Minute // Default point in time keep alive between pages
+	defaultCursorPageSize  = 100         // Default page size of the cursor based pagination
+	defaultStreamPageSize  = 1000        // Default page size of the stream iterator
+)
+
+// queryCursor is the state of the cursor based pagination, it is passed to the caller as an opaque token
+type queryCursor struct {
+	PitID       string             `json:"pit"`             // The point in time ID
+	SearchAfter []types.FieldValue `json:"after,omitempty"` // The sort values of the last hit
+}
+
+// Encode the cursor to opaque token
+func (c queryCursor) encode() (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// Decode the cursor from opaque token
+func decodeCursor(token string) (queryCursor, error) {
+	cursor := queryCursor{}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return cursor, fmt.Errorf("invalid cursor: %w", err)
+	}
+	if err = json.Unmarshal(data, &cursor); err != nil {
+		return cursor, fmt.Errorf("invalid cursor: %w", err)
+	}
+	if len(cursor.PitID) == 0 {
+		return cursor, fmt.Errorf("invalid cursor: missing point in time")
+	}
+	return cursor, nil
+}
+
+// endregion
+
+// region QueryBuilder Cursor Methods ----------------------------------------------------------------------------------
+
+// KeepAlive sets how long the point in time of the cursor is kept between pages (default: 1 minute)
+func (s *elasticDatastoreQuery) KeepAlive(keepAlive time.Duration) IElasticQuery {
+	s.keepAlive = keepAlive
+	return s
+}
+
+// FindAfter executes the query with cursor based pagination: the first call (empty cursor) opens a point in time over
+// the indices of the query, the next pages are fetched by search_after the last hit of the previous page, so the pages
+// are consistent while data is written and are not limited by the max result window. The page size is set by Limit
+// (default: 100)
+// The returned cursor is empty when there are no more results (the point in time is closed), if the caller stops
+// before the last page the cursor should be closed by ElasticStore.CloseCursor (otherwise it expires after the keep alive)
+// Returns ErrCursorExpired if the point in time of the cursor expired
+func (s *elasticDatastoreQuery) FindAfter(cursor string, keys ...string) ([]Entity, string, error) {
+	size := s.limit
+	if !s.limitSet || size <= 0 {
+		size = defaultCursorPageSize
+	}
+	return s.findAfter(s.dbs.getContext(), cursor, size, keys...)
+}
+
+// Stream iterates over all the documents meeting the criteria (page by page using point in time and search_after),
+// the query callbacks are applied on each entity. Only a single page is held in memory, the page size is set by Limit
+// (default: 1000). The iteration stops on the first error (yielded with nil entity), when the context is canceled or
+// when the caller breaks the loop, the point in time is closed in all cases
+func (s *elasticDatastoreQuery) Stream(ctx context.Context, keys ...string) iter.Seq2[Entity, error] {
+	return func(yield func(Entity, error) bool) {
+
+		size := s.limit
+		if !s.limitSet || size <= 0 {
+			size = defaultStreamPageSize
+		}
+
+		cursor := ""
+		for {
+			list, next, err := s.findAfter(ctx, cursor, size, keys...)
+			if err != nil {
+				yield(nil, err)
+				_ = s.dbs.closeCursor(context.WithoutCancel(ctx), cursor)
+				return
+			}
+			for _, entity := range list {
+				if !yield(entity, nil) {
+					_ = s.dbs.closeCursor(context.WithoutCancel(ctx), next)
+					return
+				}
+			}
+			if cursor = next; len(cursor) == 0 {
+				return
+			}
+		}
+	}
+}
+
+// Execute single page of the cursor based pagination (see FindAfter)
+func (s *elasticDatastoreQuery) findAfter(ctx context.Context, cursor string, size int, keys ...string) ([]Entity, string, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+
+	query, err := s.buildQuery()
+	if err != nil {
+		return nil, "", err
+	}
+
+	state := queryCursor{}
+	if len(cursor) == 0 {
+		if state.PitID, err = s.openPointInTime(ctx, keys...); err != nil {
+			return nil, "", err
+		}
+	} else if state, err = decodeCursor(cursor); err != nil {
+		return nil, "", err
+	}
+
+	// The shard doc tiebreaker provides total order of the hits in the point in time
+	sort := append(s.buildSort(), types.SortOptions{SortOptions: map[string]types.FieldSort{"_shard_doc": {Order: &sortorder.Asc}}})
+	req := &search.Request{
+		Size:        &size,
+		Query:       query,
+		Sort:        sort,
+		Pit:         &types.PointInTimeReference{Id: state.PitID, KeepAlive: s.cursorKeepAlive()},
+		SearchAfter: state.SearchAfter,
+	}
+
+	searchObject := s.dbs.tClient.Search().Request(req)
+	s.logLastQuery(searchObject)
+	res, err := searchObject.Do(ctx)
+	if err != nil {
+		if len(cursor) == 0 {
+			_ = s.dbs.closePointInTime(context.WithoutCancel(ctx), state.PitID)
+		}
+		return nil, "", ElasticError(err)
+	}
+
+	result := make([]Entity, 0, len(res.Hits.Hits))
+	for _, hit := range res.Hits.Hits {
+		entity := s.factory()
+		if jer := json.Unmarshal(hit.Source_, &entity); jer == nil {
+			if transformed := s.processCallbacks(entity); transformed != nil {
+				result = append(result, transformed)
+			}
+		}
+	}
+
+	// The point in time ID may change between requests
+	if res.PitId != nil && len(*res.PitId) > 0 {
+		state.PitID = *res.PitId
+	}
+
+	// Last page
+	if len(res.Hits.Hits) < size {
+		return result, "", s.dbs.closePointInTime(ctx, state.PitID)
+	}
+
+	state.SearchAfter = res.Hits.Hits[len(res.Hits.Hits)-1].Sort
+	next, err := state.encode()
+	return result, next, err
+}
+
+// Open point in time over the indices of the query
+func (s *elasticDatastoreQuery) openPointInTime(ctx context.Context, keys ...string) (string, error) {
+	indices := s.searchIndices(keys...)
+	res, err := s.dbs.tClient.OpenPointInTime(strings.Join(indices, ",")).
+		KeepAlive(s.cursorKeepAlive()).
+		ExpandWildcards(expandwildcard.All).
+		IgnoreUnavailable(true).
+		Do(ctx)
+	if err != nil {
+		return "", ElasticError(err)
+	}
+	return res.Id, nil
+}
+
+// Get the point in time keep alive in elasticsearch time units
+func (s *elasticDatastoreQuery) cursorKeepAlive() string {
+	keepAlive := s.keepAlive
+	if keepAlive <= 0 {
+		keepAlive = defaultCursorKeepAlive
+	}
+	return fmt.Sprintf("%dms", keepAlive.Milliseconds())
+}
+
+// endregion
+
+// region Datastore Cursor Methods -------------------------------------------------------------------------------------
+
+// CloseCursor releases the point in time of a cursor returned by FindAfter (closing expired cursor is not an error)
+func (dbs *ElasticStore) CloseCursor(cursor string) error {
+	return dbs.closeCursor(dbs.getContext(), cursor)
+}
+
+// Close the point in time of the cursor (empty cursor is ignored)
+func (dbs *ElasticStore) closeCursor(ctx context.Context, cursor string) error {
+	if len(cursor) == 0 {
+		return nil
+	}
+	if state, err := decodeCursor(cursor); err != nil {
+		return err
+	} else {
+		return dbs.closePointInTime(ctx, state.PitID)
+	}
+}
+
+// Close point in time, missing (expired) point in time is ignored
+func (dbs *ElasticStore) closePointInTime(ctx context.Context, pitID string) error {
+	if _, err := dbs.tClient.ClosePointInTime().Id(pitID).Do(ctx); err != nil {
+		if err = ElasticError(err); errors.Is(err, ErrNotFound) || errors.Is(err, ErrCursorExpired) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// endregion
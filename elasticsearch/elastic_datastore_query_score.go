@@ -0,0 +1,169 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/fieldvaluefactormodifier"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/functionboostmode"
+
+	. "github.com/go-yaaf/yaaf-common/entity"
+)
+
+// region Relevance score definitions ----------------------------------------------------------------------------------
+
+// ScoredEntity is a query result entity with its relevance score
+type ScoredEntity struct {
+	Entity Entity  // The result entity
+	Score  float64 // The relevance score of the hit (_score)
+}
+
+// ScoreFunction modifies the relevance score of the hits (function_score), created by RecencyBoost or FieldValueFactor
+// The scores of all the functions are multiplied, and the result is multiplied by the query score (or replaces it when
+// the query has only filters)
+type ScoreFunction struct {
+	decayField  string
+	origin      Timestamp
+	scale       time.Duration
+	offset      time.Duration
+	decay       float64
+	factorField string
+	factor      float64
+	modifier    string
+	missing     *float64
+	weight      float64
+}
+
+// RecencyBoost creates a gauss decay function on a timestamp field: documents at the origin (default: now) get a full
+// score, documents at the scale distance from the origin get the decay score (e.g. 0.5), and it keeps decaying beyond
+func RecencyBoost(field string, scale time.Duration, decay float64) ScoreFunction {
+	return ScoreFunction{decayField: field, scale: scale, decay: decay}
+}
+
+// FieldValueFactor creates a function using a numeric field to influence the score: factor * modifier(field value)
+// The modifier is one of: none (default), log, log1p, log2p, ln, ln1p, ln2p, square, sqrt or reciprocal
+func FieldValueFactor(field string, factor float64, modifier string) ScoreFunction {
+	return ScoreFunction{factorField: field, factor: factor, modifier: modifier}
+}
+
+// Origin sets the origin of the RecencyBoost decay (default: now)
+func (f ScoreFunction) Origin(origin Timestamp) ScoreFunction {
+	f.origin = origin
+	return f
+}
+
+// Offset sets the distance from the origin of the RecencyBoost decay within which the score is not reduced
+func (f ScoreFunction) Offset(offset time.Duration) ScoreFunction {
+	f.offset = offset
+	return f
+}
+
+// Missing sets the FieldValueFactor value of documents without the field (otherwise the function fails on them)
+func (f ScoreFunction) Missing(value float64) ScoreFunction {
+	f.missing = &value
+	return f
+}
+
+// Weight multiplies the score of the function
+func (f ScoreFunction) Weight(weight float64) ScoreFunction {
+	f.weight = weight
+	return f
+}
+
+// Convert the score function to typedAPI function
+func (f ScoreFunction) function() (types.FunctionScore, error) {
+
+	result := types.FunctionScore{}
+	if f.weight > 0 {
+		weight := types.Float64(f.weight)
+		result.Weight = &weight
+	}
+
+	if len(f.factorField) > 0 {
+		fvf := &types.FieldValueFactorScoreFunction{Field: f.factorField}
+		if f.factor > 0 {
+			factor := types.Float64(f.factor)
+			fvf.Factor = &factor
+		}
+		if len(f.modifier) > 0 {
+			fvf.Modifier = &fieldvaluefactormodifier.FieldValueFactorModifier{Name: f.modifier}
+		}
+		if f.missing != nil {
+			missing := types.Float64(*f.missing)
+			fvf.Missing = &missing
+		}
+		result.FieldValueFactor = fvf
+		return result, nil
+	}
+
+	if len(f.decayField) == 0 || f.scale <= 0 {
+		return result, fmt.Errorf("invalid score function: missing field or scale")
+	}
+	if f.decay <= 0 || f.decay >= 1 {
+		return result, fmt.Errorf("invalid score function: decay must be between 0 and 1")
+	}
+
+	origin := "now"
+	if f.origin > 0 {
+		origin = fmt.Sprintf("%d", f.origin)
+	}
+	decay := types.Float64(f.decay)
+	placement := types.DecayPlacementDateMathDuration{Origin: &origin, Scale: fmt.Sprintf("%dms", f.scale.Milliseconds()), Decay: &decay}
+	if f.offset > 0 {
+		placement.Offset = fmt.Sprintf("%dms", f.offset.Milliseconds())
+	}
+	result.Gauss = types.DateDecayFunction{DateDecayFunction: map[string]types.DecayPlacementDateMathDuration{f.decayField: placement}}
+	return result, nil
+}
+
+// endregion
+
+// region QueryBuilder Relevance Methods -------------------------------------------------------------------------------
+
+// Should adds optional scored clauses: documents are not required to match them, but matching documents score higher
+// (use Boost to weight the clauses). Use MatchText for required (must) clauses
+func (s *elasticDatastoreQuery) Should(queries ...TextQuery) IElasticQuery {
+	for _, q := range queries {
+		if q.IsActive() {
+			s.shouldTexts = append(s.shouldTexts, q)
+		}
+	}
+	return s
+}
+
+// ScoreWith adds functions to modify the relevance score of the hits (see RecencyBoost and FieldValueFactor)
+func (s *elasticDatastoreQuery) ScoreWith(functions ...ScoreFunction) IElasticQuery {
+	s.scoreFunctions = append(s.scoreFunctions, functions...)
+	return s
+}
+
+// FindScored executes the query based on the criteria, order and pagination and returns the entities with the relevance
+// score of each hit. Without Sort the hits are ordered by score, otherwise the scores are still calculated (track_scores)
+func (s *elasticDatastoreQuery) FindScored(keys ...string) ([]ScoredEntity, TotalHits, error) {
+	return s.find(true, keys...)
+}
+
+// Wrap the query with function score query if score functions are defined
+// When the query has no scored clauses (only filters, which score 0), the functions score replaces the query score
+func (s *elasticDatastoreQuery) buildScoreQuery(query *types.Query) (*types.Query, error) {
+	if len(s.scoreFunctions) == 0 {
+		return query, nil
+	}
+
+	functions := make([]types.FunctionScore, 0, len(s.scoreFunctions))
+	for _, sf := range s.scoreFunctions {
+		if f, err := sf.function(); err != nil {
+			return nil, err
+		} else {
+			functions = append(functions, f)
+		}
+	}
+	fsq := &types.FunctionScoreQuery{Query: query, Functions: functions}
+	if len(s.allTexts) == 0 && len(s.anyTexts) == 0 && len(s.shouldTexts) == 0 {
+		fsq.BoostMode = &functionboostmode.Replace
+	}
+	return &types.Query{FunctionScore: fsq}, nil
+}
+
+// endregion
@@ -0,0 +1,253 @@
+package elasticsearch
+
+import (
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/operator"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/textquerytype"
+
+	. "github.com/go-yaaf/yaaf-common/database"
+)
+
+// region Full-text query definitions ----------------------------------------------------------------------------------
+
+// textQueryKind is the type of the full-text query
+type textQueryKind int
+
+const (
+	textMatch textQueryKind = iota
+	textMatchPhrase
+	textMatchPhrasePrefix
+	textMultiMatch
+	textFuzzy
+	textSimpleQueryString
+	textFilter
+)
+
+// TextQuery is an analyzed full-text query clause (scored by relevance) created by Match, MatchPhrase, MatchPhrasePrefix,
+// MultiMatch, Fuzzy or SimpleQueryString. Unlike QueryFilter (exact terms), the text is analyzed by the field analyzer
+// A QueryFilter can be used as a scored clause by ScoredFilter
+type TextQuery struct {
+	kind               textQueryKind
+	filter             QueryFilter
+	field              string
+	fields             []string
+	text               string
+	and                bool
+	minimumShouldMatch string
+	fuzziness          string
+	slop               *int
+	maxExpansions      *int
+	multiMatchType     string
+	flags              string
+	boost              float32
+}
+
+// Match creates a match query: the text is analyzed and any of the terms should match (see And, MinimumShouldMatch)
+func Match(field, text string) TextQuery {
+	return TextQuery{kind: textMatch, field: field, text: text}
+}
+
+// MatchPhrase creates a match_phrase query: all the terms should match in the same order (see Slop)
+func MatchPhrase(field, text string) TextQuery {
+	return TextQuery{kind: textMatchPhrase, field: field, text: text}
+}
+
+// MatchPhrasePrefix creates a match_phrase_prefix query: like MatchPhrase, the last term is a prefix (search as you type)
+func MatchPhrasePrefix(field, text string) TextQuery {
+	return TextQuery{kind: textMatchPhrasePrefix, field: field, text: text}
+}
+
+// MultiMatch creates a multi_match query over the fields, a field can be boosted using caret notation: "name^3"
+func MultiMatch(text string, fields ...string) TextQuery {
+	return TextQuery{kind: textMultiMatch, fields: fields, text: text}
+}
+
+// Fuzzy creates a fuzzy query: the term (not analyzed) matches terms within the edit distance (default: AUTO)
+func Fuzzy(field, value string) TextQuery {
+	return TextQuery{kind: textFuzzy, field: field, text: value, fuzziness: "AUTO"}
+}
+
+// SimpleQueryString creates a simple_query_string query for user input over the fields (all fields if not set)
+// The syntax (+, |, -, "phrase", prefix*) never fails on invalid input and format errors on non text fields are ignored
+func SimpleQueryString(text string, fields ...string) TextQuery {
+	return TextQuery{kind: textSimpleQueryString, fields: fields, text: text}
+}
+
+// ScoredFilter converts the filter to a scored clause, matching documents get a constant score (1 or the Boost)
+// Use it in Should to boost documents by exact field values, e.g. ScoredFilter(F("type").Eq("Marvel")).Boost(2)
+func ScoredFilter(filter QueryFilter) TextQuery {
+	return TextQuery{kind: textFilter, filter: filter}
+}
+
+// And requires all the terms of the text to match (default: any term), applies to Match, MultiMatch and SimpleQueryString
+func (q TextQuery) And() TextQuery {
+	q.and = true
+	return q
+}
+
+// MinimumShouldMatch sets the minimum number (e.g. "2") or percentage (e.g. "75%") of terms that should match
+func (q TextQuery) MinimumShouldMatch(value string) TextQuery {
+	q.minimumShouldMatch = value
+	return q
+}
+
+// Fuzziness sets the maximum edit distance of the terms: "0", "1", "2" or "AUTO", applies to Match, MultiMatch and Fuzzy
+func (q TextQuery) Fuzziness(value string) TextQuery {
+	q.fuzziness = value
+	return q
+}
+
+// Slop sets the maximum number of positions allowed between the phrase terms
+func (q TextQuery) Slop(slop int) TextQuery {
+	q.slop = &slop
+	return q
+}
+
+// MaxExpansions sets the maximum number of terms the prefix or the fuzzy term expands to
+func (q TextQuery) MaxExpansions(value int) TextQuery {
+	q.maxExpansions = &value
+	return q
+}
+
+// Type sets how the MultiMatch query is executed: best_fields (default), most_fields, cross_fields, phrase, phrase_prefix
+// or bool_prefix
+func (q TextQuery) Type(value string) TextQuery {
+	q.multiMatchType = value
+	return q
+}
+
+// Flags sets the operators enabled for the SimpleQueryString syntax, e.g. "AND|OR|PHRASE" (default: ALL)
+func (q TextQuery) Flags(flags string) TextQuery {
+	q.flags = flags
+	return q
+}
+
+// Boost increases (> 1) or decreases (< 1) the relevance score of the clause
+func (q TextQuery) Boost(boost float32) TextQuery {
+	q.boost = boost
+	return q
+}
+
+// IsActive returns true if the query has text (empty text is ignored, like inactive filters)
+func (q TextQuery) IsActive() bool {
+	if q.kind == textFilter {
+		return q.filter != nil && q.filter.IsActive()
+	}
+	return len(strings.TrimSpace(q.text)) > 0
+}
+
+// Convert the text query to typedAPI query
+func (q TextQuery) query() types.Query {
+
+	var boost *float32
+	if q.boost > 0 {
+		boost = &q.boost
+	}
+	var op *operator.Operator
+	if q.and {
+		op = &operator.And
+	}
+	var msm types.MinimumShouldMatch
+	if len(q.minimumShouldMatch) > 0 {
+		msm = q.minimumShouldMatch
+	}
+	var fuzziness types.Fuzziness
+	if len(q.fuzziness) > 0 {
+		fuzziness = q.fuzziness
+	}
+
+	switch q.kind {
+	case textMatchPhrase:
+		return types.Query{MatchPhrase: map[string]types.MatchPhraseQuery{
+			q.field: {Query: q.text, Slop: q.slop, Boost: boost},
+		}}
+	case textMatchPhrasePrefix:
+		return types.Query{MatchPhrasePrefix: map[string]types.MatchPhrasePrefixQuery{
+			q.field: {Query: q.text, Slop: q.slop, MaxExpansions: q.maxExpansions, Boost: boost},
+		}}
+	case textMultiMatch:
+		mmq := &types.MultiMatchQuery{Query: q.text, Fields: q.fields, Operator: op, MinimumShouldMatch: msm,
+			Fuzziness: fuzziness, Slop: q.slop, MaxExpansions: q.maxExpansions, Boost: boost}
+		if len(q.multiMatchType) > 0 {
+			mmq.Type = &textquerytype.TextQueryType{Name: q.multiMatchType}
+		}
+		return types.Query{MultiMatch: mmq}
+	case textFuzzy:
+		return types.Query{Fuzzy: map[string]types.FuzzyQuery{
+			q.field: {Value: q.text, Fuzziness: fuzziness, MaxExpansions: q.maxExpansions, Boost: boost},
+		}}
+	case textSimpleQueryString:
+		lenient := true
+		sqs := &types.SimpleQueryStringQuery{Query: q.text, Fields: q.fields, DefaultOperator: op,
+			MinimumShouldMatch: msm, Lenient: &lenient, Boost: boost}
+		if len(q.flags) > 0 {
+			sqs.Flags = q.flags
+		}
+		return types.Query{SimpleQueryString: sqs}
+	case textFilter:
+		return filterScoreQuery(q.filter, boost)
+	default:
+		return types.Query{Match: map[string]types.MatchQuery{
+			q.field: {Query: q.text, Operator: op, MinimumShouldMatch: msm, Fuzziness: fuzziness, Boost: boost},
+		}}
+	}
+}
+
+// Convert the filter to constant score query (negative filters are wrapped by bool must_not)
+func filterScoreQuery(qf QueryFilter, boost *float32) types.Query {
+	f, inc := queryTerms[qf.GetOperator()](qf)
+	if f == nil {
+		f = &types.Query{MatchAll: types.NewMatchAllQuery()}
+	} else if !inc {
+		f = &types.Query{Bool: &types.BoolQuery{MustNot: []types.Query{*f}}}
+	}
+	return types.Query{ConstantScore: &types.ConstantScoreQuery{Filter: f, Boost: boost}}
+}
+
+// endregion
+
+// region QueryBuilder Full-text Methods -------------------------------------------------------------------------------
+
+// MatchText adds full-text queries, all of them should match (AND), the hits are scored by relevance
+func (s *elasticDatastoreQuery) MatchText(queries ...TextQuery) IElasticQuery {
+	for _, q := range queries {
+		if q.IsActive() {
+			s.allTexts = append(s.allTexts, q)
+		}
+	}
+	return s
+}
+
+// MatchAnyText adds list of full-text queries, any of them should match (OR), the hits are scored by relevance
+func (s *elasticDatastoreQuery) MatchAnyText(queries ...TextQuery) IElasticQuery {
+	list := make([]TextQuery, 0)
+	for _, q := range queries {
+		if q.IsActive() {
+			list = append(list, q)
+		}
+	}
+	if len(list) > 0 {
+		s.anyTexts = append(s.anyTexts, list)
+	}
+	return s
+}
+
+// Build the full-text (scored) clauses of the bool query
+func (s *elasticDatastoreQuery) buildTextQueries() []types.Query {
+	result := make([]types.Query, 0)
+	for _, q := range s.allTexts {
+		result = append(result, q.query())
+	}
+	for _, list := range s.anyTexts {
+		should := make([]types.Query, 0, len(list))
+		for _, q := range list {
+			should = append(should, q.query())
+		}
+		result = append(result, types.Query{Bool: &types.BoolQuery{Should: should, MinimumShouldMatch: 1}})
+	}
+	return result
+}
+
+// endregion
@@ -0,0 +1,136 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/scriptlanguage"
+
+	. "github.com/go-yaaf/yaaf-common/entity"
+)
+
+// region Script definitions -------------------------------------------------------------------------------------------
+
+// Script is a server side script used to update documents (Painless by default)
+// The script accesses the document source via ctx._source and the parameters via params
+type Script struct {
+	Source string         // The script source
+	Params map[string]any // Named parameters passed to the script (use parameters instead of hard-coded values)
+	Lang   string         // The script language (default: painless)
+}
+
+// NewScript creates a Painless script with parameters
+func NewScript(source string, params map[string]any) Script {
+	return Script{Source: source, Params: params}
+}
+
+// IncrementScript creates a script to increment a numeric field by delta (missing field is initialized to delta)
+func IncrementScript(field string, delta int64) Script {
+	return NewScript(
+		`if (ctx._source[params.field] == null) { ctx._source[params.field] = params.delta } else { ctx._source[params.field] += params.delta }`,
+		map[string]any{"field": field, "delta": delta},
+	)
+}
+
+// DecrementScript creates a script to decrement a numeric field by delta (missing field is initialized to -delta)
+func DecrementScript(field string, delta int64) Script {
+	return IncrementScript(field, -delta)
+}
+
+// AddToSetScript creates a script to append values to an array field, only values not already in the array are added
+func AddToSetScript(field string, values ...any) Script {
+	return NewScript(
+		`if (ctx._source[params.field] == null) { ctx._source[params.field] = new ArrayList() }
+for (v in params.values) { if (!ctx._source[params.field].contains(v)) { ctx._source[params.field].add(v) } }`,
+		map[string]any{"field": field, "values": values},
+	)
+}
+
+// RemoveFromArrayScript creates a script to remove all the occurrences of the values from an array field
+func RemoveFromArrayScript(field string, values ...any) Script {
+	return NewScript(
+		`if (ctx._source[params.field] != null) { ctx._source[params.field].removeIf(v -> params.values.contains(v)) }`,
+		map[string]any{"field": field, "values": values},
+	)
+}
+
+// SetIfGreaterScript creates a script to set the field value only if the new value is greater than the current value
+// (or the field is missing), otherwise the operation is a no-op and the document is not changed
+func SetIfGreaterScript(field string, value any) Script {
+	return NewScript(
+		`if (ctx._source[params.field] == null || ctx._source[params.field] < params.value) { ctx._source[params.field] = params.value } else { ctx.op = 'noop' }`,
+		map[string]any{"field": field, "value": value},
+	)
+}
+
+// Create a script to set the fields values, field names and values are passed as parameters (sorted by field name)
+func setFieldsScript(fields map[string]any) Script {
+
+	names := make([]string, 0, len(fields))
+	for k := range fields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	params := make(map[string]any)
+	for i, name := range names {
+		sb.WriteString(fmt.Sprintf("ctx._source[params.f%d] = params.v%d; ", i, i))
+		params[fmt.Sprintf("f%d", i)] = name
+		params[fmt.Sprintf("v%d", i)] = fields[name]
+	}
+	return NewScript(strings.TrimSpace(sb.String()), params)
+}
+
+// Convert script to elasticsearch inline script
+func (s Script) inlineScript() (*types.InlineScript, error) {
+
+	if len(s.Source) == 0 {
+		return nil, fmt.Errorf("script source is empty")
+	}
+
+	result := &types.InlineScript{Source: s.Source, Params: make(map[string]json.RawMessage)}
+	if len(s.Lang) > 0 {
+		result.Lang = &scriptlanguage.ScriptLanguage{Name: s.Lang}
+	}
+	for k, v := range s.Params {
+		if data, err := json.Marshal(v); err != nil {
+			return nil, fmt.Errorf("invalid script parameter %s: %s", k, err)
+		} else {
+			result.Params[k] = data
+		}
+	}
+	return result, nil
+}
+
+// endregion
+
+// region Scripted update methods --------------------------------------------------------------------------------------
+
+// UpdateWithScript updates a single document by executing the script on the server side in a single atomic request
+// Returns an error of kind ErrNotFound if the document does not exist
+func (dbs *ElasticStore) UpdateWithScript(factory EntityFactory, entityID string, script Script, keys ...string) error {
+
+	inline, err := script.inlineScript()
+	if err != nil {
+		return err
+	}
+
+	index, err := dbs.resolveWriteIndex(factory().TABLE(), entityID, keys...)
+	if err != nil {
+		return err
+	}
+
+	if _, err = dbs.tClient.Update(index, entityID).
+		Script(inline).
+		RetryOnConflict(dbs.cfg.retryOnConflict).
+		Do(dbs.getContext()); err != nil {
+		return ElasticError(err)
+	}
+	return nil
+}
+
+// endregion
@@ -0,0 +1,222 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	elastic "github.com/elastic/go-elasticsearch/v8"
+)
+
+// region TLS options definitions --------------------------------------------------------------------------------------
+
+// TLSOptions holds the TLS settings used to connect to the elasticsearch cluster
+// Certificates and keys can be provided either as PEM encoded content or as a path to a PEM file
+type TLSOptions struct {
+	CACert                 []byte // PEM encoded CA certificate(s) bundle used to verify the cluster certificate
+	CACertFile             string // Path to PEM encoded CA certificate(s) bundle
+	ClientCert             []byte // PEM encoded client certificate (for mTLS)
+	ClientCertFile         string // Path to PEM encoded client certificate
+	ClientKey              []byte // PEM encoded client private key (for mTLS)
+	ClientKeyFile          string // Path to PEM encoded client private key
+	CertificateFingerprint string // SHA256 hex fingerprint of the cluster CA certificate (colons are allowed)
+	InsecureSkipVerify     bool   // Skip server certificate verification, use for development only!
+}
+
+// IsEmpty returns true if no TLS option was set
+func (o TLSOptions) IsEmpty() bool {
+	return len(o.CACert) == 0 && len(o.CACertFile) == 0 &&
+		len(o.ClientCert) == 0 && len(o.ClientCertFile) == 0 &&
+		len(o.ClientKey) == 0 && len(o.ClientKeyFile) == 0 &&
+		len(o.CertificateFingerprint) == 0 && !o.InsecureSkipVerify
+}
+
+// endregion
+
+// region TLS options helper methods -----------------------------------------------------------------------------------
+
+// Parse TLS options from the URI query parameters:
+// tls_ca (CA file path or URL encoded PEM), tls_cert (client certificate file path or PEM), tls_key (client key file path or PEM),
+// tls_fingerprint (CA certificate SHA256 fingerprint) and tls_insecure (true to skip server certificate verification)
+func parseTLSOptions(query url.Values) (opts TLSOptions, err error) {
+
+	if ca := query.Get("tls_ca"); len(ca) > 0 {
+		if isPEM(ca) {
+			opts.CACert = []byte(ca)
+		} else {
+			opts.CACertFile = ca
+		}
+	}
+
+	if cert := query.Get("tls_cert"); len(cert) > 0 {
+		if isPEM(cert) {
+			opts.ClientCert = []byte(cert)
+		} else {
+			opts.ClientCertFile = cert
+		}
+	}
+
+	if key := query.Get("tls_key"); len(key) > 0 {
+		if isPEM(key) {
+			opts.ClientKey = []byte(key)
+		} else {
+			opts.ClientKeyFile = key
+		}
+	}
+
+	opts.CertificateFingerprint = query.Get("tls_fingerprint")
+
+	if insecure := query.Get("tls_insecure"); len(insecure) > 0 {
+		if opts.InsecureSkipVerify, err = strconv.ParseBool(insecure); err != nil {
+			return opts, fmt.Errorf("invalid tls_insecure value: %s", insecure)
+		}
+	}
+	return opts, nil
+}
+
+// Apply TLS options to the elasticsearch client configuration
+func (o TLSOptions) apply(cfg *elastic.Config) error {
+
+	if o.IsEmpty() {
+		return nil
+	}
+
+	tlsConfig, err := o.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	// Set the TLS configuration on the provided transport or on a clone of the default transport
+	var transport *http.Transport
+	if cfg.Transport == nil {
+		if dt, ok := http.DefaultTransport.(*http.Transport); !ok {
+			return errors.New("cannot clone http.DefaultTransport")
+		} else {
+			transport = dt.Clone()
+		}
+	} else {
+		if ht, ok := cfg.Transport.(*http.Transport); !ok {
+			return fmt.Errorf("unable to set TLS configuration for transport of type %T", cfg.Transport)
+		} else {
+			transport = ht.Clone()
+		}
+	}
+	transport.TLSClientConfig = tlsConfig
+	cfg.Transport = transport
+	return nil
+}
+
+// Build the TLS configuration
+func (o TLSOptions) tlsConfig() (*tls.Config, error) {
+
+	caCert, err := readPEM(o.CACert, o.CACertFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA certificate: %s", err)
+	}
+	clientCert, err := readPEM(o.ClientCert, o.ClientCertFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading client certificate: %s", err)
+	}
+	clientKey, err := readPEM(o.ClientKey, o.ClientKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading client key: %s", err)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+
+	if len(caCert) > 0 {
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if ok := tlsConfig.RootCAs.AppendCertsFromPEM(caCert); !ok {
+			return nil, errors.New("unable to add CA certificate")
+		}
+	}
+
+	// The standard verification is replaced by the fingerprint verification, when the CA certificate is provided
+	// as well the certificate chain is verified against it (unless the verification is explicitly skipped)
+	if len(o.CertificateFingerprint) > 0 {
+		fingerprint, er := hex.DecodeString(strings.ReplaceAll(o.CertificateFingerprint, ":", ""))
+		if er != nil {
+			return nil, fmt.Errorf("invalid certificate fingerprint: %s", o.CertificateFingerprint)
+		}
+		roots := tlsConfig.RootCAs
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			if err := verifyFingerprint(cs.PeerCertificates, fingerprint); err != nil {
+				return fmt.Errorf("%s, provided: %s", err, o.CertificateFingerprint)
+			}
+			if roots == nil || o.InsecureSkipVerify {
+				return nil
+			}
+			return verifyChain(cs, roots)
+		}
+	}
+
+	if len(clientCert) > 0 || len(clientKey) > 0 {
+		if len(clientCert) == 0 || len(clientKey) == 0 {
+			return nil, errors.New("both client certificate and client key must be provided")
+		}
+		if pair, er := tls.X509KeyPair(clientCert, clientKey); er != nil {
+			return nil, fmt.Errorf("error loading client certificate: %s", er)
+		} else {
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+	}
+	return tlsConfig, nil
+}
+
+// Check that the SHA256 fingerprint matches at least one certificate of the remote chain
+func verifyFingerprint(certs []*x509.Certificate, fingerprint []byte) error {
+	for _, cert := range certs {
+		if digest := sha256.Sum256(cert.Raw); bytes.Equal(digest[:], fingerprint) {
+			return nil
+		}
+	}
+	return errors.New("certificate fingerprint mismatch")
+}
+
+// Verify the remote certificate chain and host name against the CA certificates
+func verifyChain(cs tls.ConnectionState, roots *x509.CertPool) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("no certificate provided by the server")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       cs.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// Get PEM content from the provided bytes or read it from the file
+func readPEM(content []byte, file string) ([]byte, error) {
+	if len(content) > 0 {
+		return content, nil
+	}
+	if len(file) == 0 {
+		return nil, nil
+	}
+	return os.ReadFile(file)
+}
+
+// Check if the value is PEM content (and not a file path)
+func isPEM(value string) bool {
+	return strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN")
+}
+
+// endregion
@@ -2,9 +2,7 @@ package elasticsearch
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
-	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
 	. "github.com/go-yaaf/yaaf-common/entity"
 	"reflect"
 	"strings"
@@ -26,11 +24,7 @@ func (dbs *ElasticStore) createEntityIndexTemplate(factory EntityFactory) (strin
 	template := Json{}
 	template["mappings"] = mappings
 
-	idxPattern := entity.TABLE()
-	if strings.Contains(idxPattern, "-") {
-		idx := strings.Index(idxPattern, "-")
-		idxPattern = fmt.Sprintf("%s-*", idxPattern[:idx])
-	}
+	idxPattern := dbs.cfg.indexPrefix + dbs.indexResolver().TemplatePattern(entity.TABLE())
 	indexTmpl := Json{}
 	indexTmpl["index_patterns"] = []string{idxPattern}
 	indexTmpl["template"] = template
@@ -131,32 +125,3 @@ func (dbs *ElasticStore) addFieldMapping(sf reflect.StructField, props Json) {
 	name := fmt.Sprintf("%s%s", strings.ToLower(sf.Name[0:1]), sf.Name[1:])
 	props[name] = spec
 }
-
-// ElasticError is wrapper for errors returned by elasticsearch to provide meaningful error
-func ElasticError(err error) error {
-
-	if err == nil {
-		return nil
-	}
-
-	var ee *types.ElasticsearchError
-	if errors.As(err, &ee) {
-		if len(ee.ErrorCause.RootCause) > 0 {
-			rootCause := ee.ErrorCause.RootCause[0]
-			if rootCause.Reason != nil {
-				return errors.New(*rootCause.Reason)
-			} else {
-				return ee
-			}
-		} else {
-			if ee.ErrorCause.Reason != nil {
-				return errors.New(*ee.ErrorCause.Reason)
-			} else {
-				return ee
-			}
-		}
-	} else {
-		return err
-	}
-
-}
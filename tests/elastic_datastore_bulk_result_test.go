@@ -0,0 +1,141 @@
+// Test bulk operations result
+package test
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/go-yaaf/yaaf-common/entity"
+	"github.com/stretchr/testify/require"
+
+	es "github.com/go-yaaf/yaaf-common-elasticsearch/elasticsearch"
+)
+
+// badHero can't be serialized to JSON
+type badHero struct {
+	*Hero
+	Callback func() `json:"callback"`
+}
+
+func TestBulkResult(t *testing.T) {
+
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		return http.StatusOK, bulkResponse(req, func(id string) int {
+			attempts[id]++
+			switch {
+			case id == "2":
+				return http.StatusTooManyRequests
+			case id == "3":
+				return http.StatusBadRequest
+			case id == "5" && attempts[id] == 1:
+				return http.StatusTooManyRequests
+			default:
+				return http.StatusCreated
+			}
+		})
+	})
+
+	store, err := es.NewElasticStoreWithOptions(
+		es.WithHosts("http://localhost:9200"),
+		es.WithTransport(transport),
+		es.WithBulkOptions(es.BulkOptions{ItemRetryBackoff: time.Millisecond}),
+	)
+	require.NoError(t, err)
+
+	list := []Entity{
+		NewHero1("1", 1, "Ironman", "Marvel", "red"),
+		NewHero1("2", 2, "Superman", "DC", "blue"),
+		NewHero1("3", 3, "Batman", "DC", "black"),
+		&badHero{Hero: NewHero1("4", 4, "Flash", "DC", "red").(*Hero), Callback: func() {}},
+		NewHero1("5", 5, "Hulk", "Marvel", "green"),
+	}
+
+	var letters []*es.DeadLetter
+	deadLetter := es.DeadLetterFunc(func(dl []*es.DeadLetter) error {
+		letters = append(letters, dl...)
+		return nil
+	})
+
+	result, err := store.BulkInsertWithResult(list, es.BulkRetry(2, time.Millisecond), es.BulkDeadLetter(deadLetter))
+	require.NoError(t, err)
+	require.Equal(t, int64(2), result.Succeeded)
+	require.Equal(t, int64(2), result.Failed)
+	require.Equal(t, int64(3), result.Retried)
+	require.Equal(t, int64(1), result.Recovered)
+	require.Equal(t, int64(2), result.DeadLettered)
+	require.Len(t, result.SerializationFailures, 1)
+	require.Equal(t, "4", result.SerializationFailures[0].ID)
+	require.ElementsMatch(t, []string{"2", "3", "4"}, result.FailedIDs())
+
+	for _, f := range result.Failures {
+		require.Equal(t, "hero-m-"+time.Now().Format("2006.01"), f.Index)
+		require.Equal(t, "index", f.Action)
+		switch f.ID {
+		case "2":
+			require.Equal(t, http.StatusTooManyRequests, f.Status)
+			require.True(t, errors.Is(f.Err, es.ErrTooManyRequests))
+		case "3":
+			require.Equal(t, "document_parsing_exception", f.Type)
+			require.Equal(t, "failed to parse field [num]", f.Reason)
+			require.True(t, errors.Is(f.Err, es.ErrMappingConflict))
+		}
+	}
+
+	// Permanent failures are not retried
+	require.Equal(t, 3, attempts["2"])
+	require.Equal(t, 1, attempts["3"])
+	require.Len(t, letters, 2)
+	for _, letter := range letters {
+		require.Contains(t, letter.Document, `"id":"`+letter.DocumentID+`"`)
+	}
+
+	// The legacy method returns the number of succeeded items
+	total, err := store.BulkInsert(list)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), total)
+}
+
+func TestBulkOptions(t *testing.T) {
+
+	var query url.Values
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		if strings.HasSuffix(req.URL.Path, "/_search") {
+			return http.StatusOK, `{"took":1,"timed_out":false,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0},
+				"hits":{"total":{"value":1,"relation":"eq"},"hits":[{"_index":"hero-m-2026.10","_id":"1","_score":1}]}}`
+		}
+		query = req.URL.Query()
+		return http.StatusOK, `{"took":3,"errors":false,"items":[{"delete":{"_index":"hero-m-2026.10","_id":"1","result":"deleted","status":200}}]}`
+	})
+
+	store, err := es.NewElasticStoreWithOptions(
+		es.WithHosts("http://localhost:9200"),
+		es.WithTransport(transport),
+		es.WithBulkOptions(es.BulkOptions{Refresh: es.RefreshTrue, Pipeline: "heroes"}),
+	)
+	require.NoError(t, err)
+
+	// Store settings
+	_, err = store.BulkDeleteWithResult(NewHero, []string{"1"}, "m")
+	require.NoError(t, err)
+	require.Equal(t, "true", query.Get("refresh"))
+	require.Equal(t, "heroes", query.Get("pipeline"))
+
+	// Per call settings
+	result, err := store.BulkDeleteWithOptions(NewHero, []string{"1"},
+		[]es.BulkOption{es.BulkRefresh(es.RefreshWaitFor), es.BulkPipeline("enrich"), es.BulkRouting("m"), es.BulkTimeout(time.Second), es.BulkWorkers(1)}, "m")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), result.Succeeded)
+	require.Equal(t, "wait_for", query.Get("refresh"))
+	require.Equal(t, "enrich", query.Get("pipeline"))
+	require.Equal(t, "m", query.Get("routing"))
+	require.Equal(t, "1000ms", query.Get("timeout"))
+}
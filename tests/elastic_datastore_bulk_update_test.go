@@ -0,0 +1,217 @@
+// Test bulk partial updates and upserts
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/go-yaaf/yaaf-common/entity"
+	"github.com/stretchr/testify/require"
+
+	es "github.com/go-yaaf/yaaf-common-elasticsearch/elasticsearch"
+)
+
+// bulkLines returns the ndjson lines of the bulk request and restores the request body
+func bulkLines(req *http.Request) []string {
+	data, _ := io.ReadAll(req.Body)
+	req.Body = io.NopCloser(bytes.NewReader(data))
+
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
+
+func TestBulkUpdateAndUpsert(t *testing.T) {
+
+	var mu sync.Mutex
+	var lines []string
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.HasSuffix(req.URL.Path, "/_search") {
+			return http.StatusOK, `{"took":1,"timed_out":false,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0},
+				"hits":{"total":{"value":2,"relation":"eq"},"hits":[
+				{"_index":"hero-m-2024.01","_id":"1","_score":1},
+				{"_index":"hero-m-2024.02","_id":"1","_score":1},
+				{"_index":"hero-m-2024.01","_id":"2","_score":1}]}}`
+		}
+		lines = append(lines, bulkLines(req)...)
+		return http.StatusOK, bulkResponse(req, func(id string) int { return http.StatusOK })
+	})
+
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport), es.WithRetryOnConflict(2))
+	require.NoError(t, err)
+
+	list := []Entity{
+		NewHero1("1", 1, "Ironman", "Marvel", "red"),
+		NewHero1("2", 2, "Superman", "DC", "blue"),
+		NewHero1("3", 3, "Batman", "DC", "black"),
+	}
+
+	// Missing documents are not created by update
+	result, err := store.BulkUpdateWithResult(list, es.BulkWorkers(1))
+	require.NoError(t, err)
+	require.Equal(t, int64(2), result.Succeeded)
+	require.Equal(t, int64(1), result.Failed)
+	require.Equal(t, []string{"3"}, result.FailedIDs())
+	require.True(t, errors.Is(result.Failures[0].Err, es.ErrNotFound))
+	require.Len(t, lines, 4)
+
+	meta := map[string]map[string]any{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &meta))
+	require.Equal(t, "hero-m-2024.02", meta["update"]["_index"])
+	require.EqualValues(t, 2, meta["update"]["retry_on_conflict"])
+	require.NoError(t, json.Unmarshal([]byte(lines[2]), &meta))
+	require.Equal(t, "hero-m-2024.01", meta["update"]["_index"])
+	require.Contains(t, lines[1], `{"doc":{`)
+	require.NotContains(t, lines[1], "doc_as_upsert")
+
+	// Missing documents are created in the current index by upsert
+	lines = nil
+	result, err = store.BulkUpsertWithResult(list, es.BulkWorkers(1))
+	require.NoError(t, err)
+	require.Equal(t, int64(3), result.Succeeded)
+	require.Len(t, lines, 6)
+	require.NoError(t, json.Unmarshal([]byte(lines[4]), &meta))
+	require.Equal(t, "hero-m-"+time.Now().Format("2006.01"), meta["update"]["_index"])
+	require.Contains(t, lines[5], `"doc_as_upsert":true`)
+}
+
+func TestBulkSetFieldsMap(t *testing.T) {
+
+	var mu sync.Mutex
+	var lines []string
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.HasSuffix(req.URL.Path, "/_search") {
+			return http.StatusOK, `{"took":1,"timed_out":false,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0},
+				"hits":{"total":{"value":2,"relation":"eq"},"hits":[
+				{"_index":"hero-m-2024.01","_id":"1","_score":1},
+				{"_index":"hero-m-2024.03","_id":"2","_score":1}]}}`
+		}
+		lines = append(lines, bulkLines(req)...)
+		return http.StatusOK, bulkResponse(req, func(id string) int { return http.StatusOK })
+	})
+
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport))
+	require.NoError(t, err)
+
+	fields := map[string]map[string]any{
+		"1": {"name": `Iron "Man"`, "num": 10, "tags": []string{"a", "b"}},
+		"2": {"color": map[string]any{"primary": "blue"}},
+		"3": {"name": "Batman"},
+	}
+	result, err := store.BulkSetFieldsMapWithOptions(NewHero, fields, []es.BulkOption{es.BulkWorkers(1)}, "m")
+	require.NoError(t, err)
+	require.Equal(t, int64(2), result.Succeeded)
+	require.Equal(t, []string{"3"}, result.FailedIDs())
+	require.Len(t, lines, 4)
+
+	meta := map[string]map[string]any{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &meta))
+	require.Equal(t, "hero-m-2024.01", meta["update"]["_index"])
+	require.JSONEq(t, `{"doc":{"name":"Iron \"Man\"","num":10,"tags":["a","b"]}}`, lines[1])
+	require.NoError(t, json.Unmarshal([]byte(lines[2]), &meta))
+	require.Equal(t, "hero-m-2024.03", meta["update"]["_index"])
+	require.JSONEq(t, `{"doc":{"color":{"primary":"blue"}}}`, lines[3])
+
+	// Single field values keep their JSON type
+	lines = nil
+	result, err = store.BulkSetFieldsWithResult(NewHero, "num", map[string]any{"1": 7}, "m")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), result.Succeeded)
+	require.JSONEq(t, `{"doc":{"num":7}}`, lines[1])
+}
+
+func TestBulkDeleteAcrossIndices(t *testing.T) {
+
+	var mu sync.Mutex
+	var lines []string
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.HasSuffix(req.URL.Path, "/_search") {
+			return http.StatusOK, `{"took":1,"timed_out":false,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0},
+				"hits":{"total":{"value":2,"relation":"eq"},"hits":[
+				{"_index":"hero-m-2024.01","_id":"1","_score":1},
+				{"_index":"hero-m-2024.02","_id":"2","_score":1}]}}`
+		}
+		lines = append(lines, bulkLines(req)...)
+		return http.StatusOK, bulkResponse(req, func(id string) int { return http.StatusOK })
+	})
+
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport))
+	require.NoError(t, err)
+
+	result, err := store.BulkDeleteWithOptions(NewHero, []string{"1", "2", "3"}, []es.BulkOption{es.BulkWorkers(1)}, "m")
+	require.NoError(t, err)
+	require.Equal(t, int64(2), result.Succeeded)
+	require.Equal(t, int64(0), result.Failed)
+	require.Equal(t, []string{"3"}, result.NotFound)
+	require.Len(t, lines, 2)
+	require.JSONEq(t, `{"delete":{"_index":"hero-m-2024.01","_id":"1"}}`, lines[0])
+	require.JSONEq(t, `{"delete":{"_index":"hero-m-2024.02","_id":"2"}}`, lines[1])
+}
+
+func TestBulkDeleteDuplicates(t *testing.T) {
+
+	var mu sync.Mutex
+	var lines []string
+	deleted := map[string]bool{}
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.HasSuffix(req.URL.Path, "/_search") {
+			return http.StatusOK, `{"took":1,"timed_out":false,"_shards":{"total":3,"successful":3,"skipped":0,"failed":0},
+				"hits":{"total":{"value":4,"relation":"eq"},"hits":[
+				{"_index":"hero-m-2024.01","_id":"1","_score":1},
+				{"_index":"hero-m-2024.03","_id":"1","_score":1},
+				{"_index":"hero-m-2024.02","_id":"2","_score":1},
+				{"_index":"hero-m-2024.02","_id":"1","_score":1}]}}`
+		}
+		lines = append(lines, bulkLines(req)...)
+		return http.StatusOK, bulkResponse(req, func(id string) int {
+			if deleted[id] {
+				return http.StatusNotFound
+			}
+			return http.StatusOK
+		})
+	})
+
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport))
+	require.NoError(t, err)
+
+	// The copies of the document in all the indices are deleted, the deleted documents are counted
+	result, err := store.BulkDeleteWithOptions(NewHero, []string{"1", "2"}, []es.BulkOption{es.BulkWorkers(1)}, "m")
+	require.NoError(t, err)
+	require.Equal(t, int64(2), result.Succeeded)
+	require.Empty(t, result.NotFound)
+	require.Len(t, lines, 4)
+	require.JSONEq(t, `{"delete":{"_index":"hero-m-2024.03","_id":"1"}}`, lines[0])
+	require.JSONEq(t, `{"delete":{"_index":"hero-m-2024.02","_id":"1"}}`, lines[1])
+	require.JSONEq(t, `{"delete":{"_index":"hero-m-2024.01","_id":"1"}}`, lines[2])
+	require.JSONEq(t, `{"delete":{"_index":"hero-m-2024.02","_id":"2"}}`, lines[3])
+
+	// Document whose copies were all deleted meanwhile is not found
+	deleted["2"] = true
+	count, err := store.BulkDelete(NewHero, []string{"1", "2"}, "m")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), count)
+
+	result, err = store.BulkDeleteWithResult(NewHero, []string{"1", "2"}, "m")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), result.Succeeded)
+	require.Equal(t, []string{"2"}, result.NotFound)
+}
@@ -0,0 +1,416 @@
+// Test long-lived bulk writer
+package test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	es "github.com/go-yaaf/yaaf-common-elasticsearch/elasticsearch"
+)
+
+// bulkResponse builds the bulk response for the request items, the status of each item is resolved by the document ID
+func bulkResponse(req *http.Request, status func(id string) int) string {
+	items := make([]string, 0)
+	scanner := bufio.NewScanner(req.Body)
+	for scanner.Scan() {
+		meta := map[string]map[string]any{}
+		if err := json.Unmarshal(scanner.Bytes(), &meta); err != nil {
+			continue
+		}
+		for action, m := range meta {
+			if _, ok := m["_id"]; !ok {
+				continue
+			}
+			id := fmt.Sprint(m["_id"])
+			if st := status(id); st >= 300 {
+				errType, reason := "es_rejected_execution_exception", "rejected execution"
+				switch st {
+				case http.StatusBadRequest:
+					errType, reason = "document_parsing_exception", "failed to parse field [num]"
+				case http.StatusServiceUnavailable:
+					errType, reason = "unavailable_shards_exception", "primary shard is not active"
+				}
+				items = append(items, fmt.Sprintf(`{"%s":{"_index":"%v","_id":"%s","status":%d,"error":{"type":"%s","reason":"%s"}}}`, action, m["_index"], id, st, errType, reason))
+			} else {
+				items = append(items, fmt.Sprintf(`{"%s":{"_index":"%v","_id":"%s","status":%d,"result":"created"}}`, action, m["_index"], id, st))
+			}
+			// Skip the document body
+			if action != "delete" {
+				scanner.Scan()
+			}
+		}
+	}
+	return fmt.Sprintf(`{"took":1,"errors":true,"items":[%s]}`, strings.Join(items, ","))
+}
+
+// idsResponse builds the search response of the ids query resolving the document indices, the hits are "index/id"
+func idsResponse(hits ...string) string {
+	items := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		index, id, _ := strings.Cut(hit, "/")
+		items = append(items, fmt.Sprintf(`{"_index":"%s","_id":"%s","_score":1}`, index, id))
+	}
+	return fmt.Sprintf(`{"took":1,"timed_out":false,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0},
+		"hits":{"total":{"value":%d,"relation":"eq"},"hits":[%s]}}`, len(items), strings.Join(items, ","))
+}
+
+func TestBulkWriter(t *testing.T) {
+
+	var mu sync.Mutex
+	var actions []string
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.HasSuffix(req.URL.Path, "/_search") {
+			return http.StatusOK, idsResponse("hero-m-2024.01/3")
+		}
+		return http.StatusOK, bulkResponse(req, func(id string) int {
+			actions = append(actions, id)
+			if id == "1" {
+				return http.StatusTooManyRequests
+			}
+			return http.StatusCreated
+		})
+	})
+
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport))
+	require.NoError(t, err)
+
+	failures := make(chan es.BulkItemFailure, 10)
+	writer, err := store.NewBulkWriter(es.BulkWriterCallbacks{
+		OnFailure: func(failure es.BulkItemFailure) { failures <- failure },
+	}, es.BulkWorkers(1), es.BulkFlushInterval(10*time.Millisecond), es.BulkRetry(0, 0))
+	require.NoError(t, err)
+
+	// Rejected item triggers backpressure
+	require.NoError(t, writer.Insert(NewHero1("1", 1, "Ironman", "Marvel", "red")))
+	select {
+	case failure := <-failures:
+		require.Equal(t, "1", failure.ID)
+		require.Equal(t, http.StatusTooManyRequests, failure.Status)
+	case <-time.After(5 * time.Second):
+		t.Fatal("failure was not reported")
+	}
+
+	start := time.Now()
+	require.NoError(t, writer.Upsert(NewHero1("2", 2, "Superman", "DC", "blue")))
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	require.NoError(t, writer.Delete(NewHero, "3", "m"))
+
+	// Close drains the pending items
+	require.NoError(t, writer.Close())
+	require.Equal(t, es.BulkWriterStats{Added: 3, Succeeded: 2, Failed: 1, Throttled: 1}, writer.Stats())
+	require.ErrorIs(t, writer.Insert(NewHero1("4", 4, "Batman", "DC", "black")), es.ErrBulkWriterClosed)
+	require.ErrorContains(t, writer.Add("merge", NewHero1("4", 4, "Batman", "DC", "black")), "unsupported")
+}
+
+func TestBulkWriterRetry(t *testing.T) {
+
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		return http.StatusOK, bulkResponse(req, func(id string) int {
+			attempts[id]++
+			switch {
+			case id == "1" && attempts[id] < 3:
+				return http.StatusTooManyRequests
+			case id == "9":
+				return http.StatusServiceUnavailable
+			default:
+				return http.StatusCreated
+			}
+		})
+	})
+
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport))
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "dead-letters.json")
+	sink, err := es.NewFileDeadLetterSink(path)
+	require.NoError(t, err)
+
+	writer, err := store.NewBulkWriter(es.BulkWriterCallbacks{},
+		es.BulkWorkers(1), es.BulkFlushInterval(5*time.Millisecond), es.BulkRetry(2, time.Millisecond), es.BulkDeadLetter(sink))
+	require.NoError(t, err)
+
+	require.NoError(t, writer.Insert(NewHero1("1", 1, "Ironman", "Marvel", "red")))
+	require.NoError(t, writer.Insert(NewHero1("9", 9, "Superman", "DC", "blue")))
+
+	// Wait for the retries to complete before closing
+	require.Eventually(t, func() bool {
+		stats := writer.Stats()
+		return stats.Succeeded+stats.Failed == 2
+	}, 5*time.Second, 5*time.Millisecond)
+	require.NoError(t, writer.Close())
+	require.NoError(t, sink.Close())
+
+	stats := writer.Stats()
+	require.Equal(t, int64(1), stats.Recovered)
+	require.Equal(t, int64(4), stats.Retried)
+	require.Equal(t, int64(1), stats.Failed)
+	require.Equal(t, int64(1), stats.DeadLettered)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	letter := es.DeadLetter{}
+	require.NoError(t, json.Unmarshal(data, &letter))
+	require.Equal(t, "9", letter.DocumentID)
+	require.Equal(t, http.StatusServiceUnavailable, letter.Status)
+	require.Equal(t, 3, letter.Attempts)
+	require.Contains(t, letter.Document, "Superman")
+}
+
+func TestBulkWriterCloseWhileAdding(t *testing.T) {
+
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		return http.StatusOK, bulkResponse(req, func(id string) int { return http.StatusTooManyRequests })
+	})
+
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport))
+	require.NoError(t, err)
+
+	failures := make(chan es.BulkItemFailure, 10)
+	writer, err := store.NewBulkWriter(es.BulkWriterCallbacks{
+		OnFailure: func(failure es.BulkItemFailure) { failures <- failure },
+	}, es.BulkWorkers(1), es.BulkFlushInterval(10*time.Millisecond), es.BulkRetry(0, 0))
+	require.NoError(t, err)
+
+	// Rejected item pauses the writer
+	require.NoError(t, writer.Insert(NewHero1("1", 1, "Ironman", "Marvel", "red")))
+	select {
+	case <-failures:
+	case <-time.After(5 * time.Second):
+		t.Fatal("failure was not reported")
+	}
+
+	// Close while the add is blocked by backpressure, the add is rejected instead of reaching the closed indexer
+	added := make(chan error, 1)
+	go func() {
+		added <- writer.Insert(NewHero1("2", 2, "Superman", "DC", "blue"))
+	}()
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, writer.Close())
+	require.ErrorIs(t, <-added, es.ErrBulkWriterClosed)
+	require.Equal(t, int64(1), writer.Stats().Added)
+}
+
+func TestBulkWriterFlushFailure(t *testing.T) {
+
+	var mu sync.Mutex
+	requests := 0
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.HasSuffix(req.URL.Path, "/_search") {
+			return http.StatusOK, idsResponse("hero-m-2024.01/3")
+		}
+		if requests++; requests == 1 {
+			return http.StatusInternalServerError, `{"error":{"type":"exception","reason":"internal error"},"status":500}`
+		}
+		return http.StatusOK, bulkResponse(req, func(id string) int { return http.StatusCreated })
+	})
+
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport))
+	require.NoError(t, err)
+
+	// The items of the failed flush are retried
+	errs := make(chan error, 10)
+	writer, err := store.NewBulkWriter(es.BulkWriterCallbacks{OnError: func(err error) { errs <- err }},
+		es.BulkWorkers(1), es.BulkFlushInterval(5*time.Millisecond), es.BulkRetry(1, time.Millisecond))
+	require.NoError(t, err)
+
+	require.NoError(t, writer.Insert(NewHero1("1", 1, "Ironman", "Marvel", "red")))
+	require.Eventually(t, func() bool { return writer.Stats().Succeeded == 1 }, 5*time.Second, 5*time.Millisecond)
+	require.NoError(t, writer.Close())
+	require.Equal(t, es.BulkWriterStats{Added: 1, Succeeded: 1, Retried: 1, Recovered: 1}, writer.Stats())
+	require.Len(t, errs, 1)
+	require.ErrorContains(t, <-errs, "500")
+
+	// Without retries the items of the failed flush are dead lettered
+	requests = 0
+	path := filepath.Join(t.TempDir(), "dead-letters.json")
+	sink, err := es.NewFileDeadLetterSink(path)
+	require.NoError(t, err)
+
+	writer, err = store.NewBulkWriter(es.BulkWriterCallbacks{},
+		es.BulkWorkers(1), es.BulkFlushInterval(time.Hour), es.BulkRetry(0, 0), es.BulkDeadLetter(sink))
+	require.NoError(t, err)
+
+	require.NoError(t, writer.Insert(NewHero1("2", 2, "Superman", "DC", "blue")))
+	require.NoError(t, writer.Delete(NewHero, "3", "m"))
+	require.NoError(t, writer.Close())
+	require.NoError(t, sink.Close())
+	require.Equal(t, es.BulkWriterStats{Added: 2, Failed: 2, DeadLettered: 2}, writer.Stats())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"Superman`)
+	require.Equal(t, 2, strings.Count(string(data), "\n"))
+}
+
+// idsQueryResponse resolves the ids query of the request by the document indices (document ID -> index names)
+func idsQueryResponse(req *http.Request, indices map[string][]string) string {
+	query := struct {
+		Query struct {
+			Ids struct {
+				Values []string `json:"values"`
+			} `json:"ids"`
+		} `json:"query"`
+	}{}
+	_ = json.NewDecoder(req.Body).Decode(&query)
+
+	hits := make([]string, 0)
+	for _, id := range query.Query.Ids.Values {
+		for _, index := range indices[id] {
+			hits = append(hits, index+"/"+id)
+		}
+	}
+	return idsResponse(hits...)
+}
+
+func TestBulkWriterPartitioned(t *testing.T) {
+
+	var mu sync.Mutex
+	var lines []string
+	searches := 0
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.HasSuffix(req.URL.Path, "/_search") {
+			searches++
+			return http.StatusOK, idsQueryResponse(req, map[string][]string{"1": {"hero-m-2024.01", "hero-m-2024.03"}, "2": {"hero-m-2024.02"}})
+		}
+		lines = append(lines, bulkLines(req)...)
+		return http.StatusOK, bulkResponse(req, func(id string) int { return http.StatusOK })
+	})
+
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport))
+	require.NoError(t, err)
+
+	writer, err := store.NewBulkWriter(es.BulkWriterCallbacks{}, es.BulkWorkers(1), es.BulkFlushInterval(time.Hour))
+	require.NoError(t, err)
+
+	// Existing documents are written to their current index, deleted from all the indices they exist in
+	require.NoError(t, writer.Upsert(NewHero1("1", 1, "Ironman", "Marvel", "red")))
+	require.NoError(t, writer.Update(NewHero1("2", 2, "Superman", "DC", "blue")))
+	require.NoError(t, writer.Delete(NewHero, "1", "m"))
+
+	// Missing documents are written to the index of the entity time (or the current index)
+	require.NoError(t, writer.Update(NewHero1("9", 9, "Batman", "DC", "black")))
+	require.NoError(t, writer.Delete(NewHero, "8", "m"))
+
+	require.NoError(t, writer.Close())
+	require.Equal(t, es.BulkWriterStats{Added: 5, Succeeded: 5}, writer.Stats())
+
+	// The indices of the batch are resolved by a single search
+	require.Equal(t, 1, searches)
+
+	meta := make([]string, 0)
+	for _, line := range lines {
+		if strings.Contains(line, `"_index"`) {
+			meta = append(meta, line)
+		}
+	}
+	current := "hero-m-" + time.Now().Format("2006.01")
+	require.Len(t, meta, 6)
+	require.JSONEq(t, `{"update":{"_index":"hero-m-2024.03","_id":"1","retry_on_conflict":3}}`, meta[0])
+	require.JSONEq(t, `{"update":{"_index":"hero-m-2024.02","_id":"2","retry_on_conflict":3}}`, meta[1])
+	require.JSONEq(t, `{"delete":{"_index":"hero-m-2024.03","_id":"1"}}`, meta[2])
+	require.JSONEq(t, `{"delete":{"_index":"hero-m-2024.01","_id":"1"}}`, meta[3])
+	require.JSONEq(t, `{"update":{"_index":"`+current+`","_id":"9","retry_on_conflict":3}}`, meta[4])
+	require.JSONEq(t, `{"delete":{"_index":"`+current+`","_id":"8"}}`, meta[5])
+}
+
+func TestBulkWriterInsertAndUpdate(t *testing.T) {
+
+	var mu sync.Mutex
+	var lines []string
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.HasSuffix(req.URL.Path, "/_search") {
+			// The inserted document is not refreshed
+			return http.StatusOK, idsResponse()
+		}
+		lines = append(lines, bulkLines(req)...)
+		return http.StatusOK, bulkResponse(req, func(id string) int { return http.StatusOK })
+	})
+
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport))
+	require.NoError(t, err)
+
+	writer, err := store.NewBulkWriter(es.BulkWriterCallbacks{}, es.BulkWorkers(1), es.BulkFlushInterval(time.Hour))
+	require.NoError(t, err)
+
+	// The update and the upsert are written to the index of the inserted document
+	hero := NewHero1("1", 1, "Ironman", "Marvel", "red")
+	require.NoError(t, writer.Insert(hero))
+	require.NoError(t, writer.Update(hero))
+	require.NoError(t, writer.Upsert(hero))
+	require.NoError(t, writer.Close())
+	require.Equal(t, es.BulkWriterStats{Added: 3, Succeeded: 3}, writer.Stats())
+
+	current := "hero-m-" + time.Now().Format("2006.01")
+	require.Len(t, lines, 6)
+	require.JSONEq(t, `{"index":{"_index":"`+current+`","_id":"1"}}`, lines[0])
+	require.JSONEq(t, `{"update":{"_index":"`+current+`","_id":"1","retry_on_conflict":3}}`, lines[2])
+	require.JSONEq(t, `{"update":{"_index":"`+current+`","_id":"1","retry_on_conflict":3}}`, lines[4])
+}
+
+func TestBulkWriterSizeFlushFailure(t *testing.T) {
+
+	var mu sync.Mutex
+	requests := 0
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if requests++; requests == 1 {
+			return http.StatusInternalServerError, `{"error":{"type":"exception","reason":"internal error"},"status":500}`
+		}
+		return http.StatusOK, bulkResponse(req, func(id string) int { return http.StatusCreated })
+	})
+
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport))
+	require.NoError(t, err)
+
+	// The batch is flushed by size, the items of the failed flush request (including the item which triggered it) are retried
+	writer, err := store.NewBulkWriter(es.BulkWriterCallbacks{},
+		es.BulkWorkers(1), es.BulkFlushBytes(500), es.BulkFlushInterval(time.Hour), es.BulkRetry(1, time.Millisecond))
+	require.NoError(t, err)
+
+	for _, id := range []string{"1", "2", "3"} {
+		require.NoError(t, writer.Insert(NewHero1(id, 1, "Ironman", "Marvel", "red")))
+	}
+	require.Eventually(t, func() bool { return writer.Stats().Succeeded == 3 }, 5*time.Second, 5*time.Millisecond)
+	require.NoError(t, writer.Close())
+	require.Equal(t, es.BulkWriterStats{Added: 3, Succeeded: 3, Retried: 3, Recovered: 3}, writer.Stats())
+
+	// Without retries all the items are dead lettered
+	requests = 0
+	path := filepath.Join(t.TempDir(), "dead-letters.json")
+	sink, err := es.NewFileDeadLetterSink(path)
+	require.NoError(t, err)
+
+	writer, err = store.NewBulkWriter(es.BulkWriterCallbacks{},
+		es.BulkWorkers(1), es.BulkFlushBytes(500), es.BulkFlushInterval(time.Hour), es.BulkRetry(0, 0), es.BulkDeadLetter(sink))
+	require.NoError(t, err)
+
+	for _, id := range []string{"1", "2", "3"} {
+		require.NoError(t, writer.Insert(NewHero1(id, 1, "Ironman", "Marvel", "red")))
+	}
+	require.NoError(t, writer.Close())
+	require.NoError(t, sink.Close())
+	require.Equal(t, es.BulkWriterStats{Added: 3, Failed: 3, DeadLettered: 3}, writer.Stats())
+}
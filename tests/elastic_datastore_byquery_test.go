@@ -0,0 +1,122 @@
+// Test server side delete and update by query operations
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	. "github.com/go-yaaf/yaaf-common/database"
+	"github.com/stretchr/testify/require"
+
+	es "github.com/go-yaaf/yaaf-common-elasticsearch/elasticsearch"
+)
+
+func TestDeleteByQuery(t *testing.T) {
+
+	var path string
+	var query url.Values
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		path, query = req.URL.Path, req.URL.Query()
+		switch {
+		case strings.HasPrefix(req.URL.Path, "/_tasks/"):
+			return http.StatusOK, `{"completed":true,"task":{"node":"n1","id":42,"type":"transport","action":"indices:data/write/delete/byquery",
+				"start_time_in_millis":1,"running_time_in_nanos":1,"cancellable":true,"headers":{},"status":{"total":500,"deleted":450}},
+				"response":{"took":100,"timed_out":false,"total":500,"deleted":498,"batches":1,"version_conflicts":2,"noops":0,"failures":[]}}`
+		case query.Get("wait_for_completion") == "false":
+			return http.StatusOK, `{"task":"n1:42"}`
+		default:
+			return http.StatusOK, `{"took":100,"timed_out":false,"total":250,"deleted":250,"batches":1,"version_conflicts":0,"noops":0,"failures":[]}`
+		}
+	})
+
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport))
+	require.NoError(t, err)
+
+	// Delete all matching documents across the monthly indices
+	deleted, err := store.Query(NewHero).Filter(F("color").Eq("red")).Delete("m")
+	require.NoError(t, err)
+	require.Equal(t, int64(250), deleted)
+	require.Equal(t, "/hero-m-*/_delete_by_query", path)
+
+	// Async mode
+	result, err := store.ElasticQuery(NewHero).
+		ByQuery(es.ByQueryOptions{ProceedOnConflicts: true, Slices: -1, RequestsPerSecond: 100, Async: true}).
+		DeleteByQuery("m")
+	require.NoError(t, err)
+	require.Equal(t, "proceed", query.Get("conflicts"))
+	require.Equal(t, "auto", query.Get("slices"))
+	require.Equal(t, "100", query.Get("requests_per_second"))
+	require.Equal(t, "n1:42", result.TaskID)
+	require.False(t, result.Completed)
+
+	// Track the task
+	result, err = store.GetTask(result.TaskID)
+	require.NoError(t, err)
+	require.Equal(t, "/_tasks/n1:42", path)
+	require.True(t, result.Completed)
+	require.Equal(t, int64(498), result.Deleted)
+	require.Equal(t, int64(2), result.VersionConflicts)
+	require.NoError(t, result.Err())
+}
+
+func TestSetFieldsByQuery(t *testing.T) {
+
+	var path string
+	var body map[string]any
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		path = req.URL.Path
+		data, _ := io.ReadAll(req.Body)
+		body = map[string]any{}
+		_ = json.Unmarshal(data, &body)
+		return http.StatusOK, `{"took":100,"timed_out":false,"total":300,"updated":299,"batches":1,"version_conflicts":0,"noops":0,
+			"failures":[{"index":"hero-m-2024.01","id":"7","status":400,"cause":{"type":"mapper_parsing_exception","reason":"failed to parse field [num]"}}]}`
+	})
+
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport))
+	require.NoError(t, err)
+
+	updated, err := store.Query(NewHero).Filter(F("color").Eq("red")).SetFields(map[string]any{"name": "Ironman", "num": 5}, "m")
+	require.Equal(t, "/hero-m-*/_update_by_query", path)
+	require.Equal(t, int64(299), updated)
+	require.True(t, errors.Is(err, es.ErrMappingConflict))
+
+	script := body["script"].(map[string]any)
+	require.Equal(t, "ctx._source[params.f0] = params.v0; ctx._source[params.f1] = params.v1;", script["source"])
+	require.Equal(t, map[string]any{"f0": "name", "v0": "Ironman", "f1": "num", "v1": float64(5)}, script["params"])
+}
+
+func TestByQueryVersionConflict(t *testing.T) {
+
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		if strings.HasSuffix(req.URL.Path, "/_delete_by_query") {
+			return http.StatusConflict, `{"took":100,"timed_out":false,"total":10,"deleted":3,"batches":1,"version_conflicts":1,"noops":0,
+				"failures":[{"index":"hero-m-2024.01","id":"5","status":409,
+				"cause":{"type":"version_conflict_engine_exception","reason":"[5]: version conflict, required seqNo [7]","index":"hero-m-2024.01"}}]}`
+		}
+		return http.StatusConflict, `{"took":100,"timed_out":false,"total":10,"updated":4,"batches":1,"version_conflicts":1,"noops":0,"failures":[]}`
+	})
+
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport))
+	require.NoError(t, err)
+
+	// The documents deleted before the conflict are reported with the error
+	deleted, err := store.Query(NewHero).Filter(F("color").Eq("red")).Delete("m")
+	require.True(t, errors.Is(err, es.ErrVersionConflict))
+	require.Equal(t, int64(3), deleted)
+
+	result, err := store.ElasticQuery(NewHero).DeleteByQuery("m")
+	require.True(t, errors.Is(err, es.ErrVersionConflict))
+	require.Equal(t, int64(10), result.Total)
+	require.Equal(t, int64(1), result.VersionConflicts)
+	require.Len(t, result.Failures, 1)
+
+	// The documents updated before the conflict are reported with the error
+	updated, err := store.Query(NewHero).Filter(F("color").Eq("red")).SetField("name", "Ironman", "m")
+	require.True(t, errors.Is(err, es.ErrVersionConflict))
+	require.Equal(t, int64(4), updated)
+}
@@ -0,0 +1,167 @@
+// Test elasticsearch datastore connection configuration
+package test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	es "github.com/go-yaaf/yaaf-common-elasticsearch/elasticsearch"
+)
+
+func TestTLSConfigFromURI(t *testing.T) {
+
+	// Skip certificate verification
+	_, err := es.NewElasticStore("elastics://localhost:9200?tls_insecure=true")
+	require.NoError(t, err)
+
+	// Certificate fingerprint (with colons)
+	_, err = es.NewElasticStore("elastics://localhost:9200?tls_fingerprint=AB:CD:EF:01")
+	require.NoError(t, err)
+
+	// Invalid boolean value
+	_, err = es.NewElasticStore("elastics://localhost:9200?tls_insecure=maybe")
+	require.Error(t, err)
+
+	// Missing CA file
+	_, err = es.NewElasticStore("elastics://localhost:9200?tls_ca=/not/existing/ca.crt")
+	require.Error(t, err)
+
+	// Client certificate without key
+	_, err = es.NewElasticStore("elastics://localhost:9200?tls_cert=-----BEGIN%20CERTIFICATE-----")
+	require.Error(t, err)
+}
+
+func TestAuthenticationConfigFromURI(t *testing.T) {
+
+	// Plain http and https schemas
+	for _, uri := range []string{"elastic://localhost:9200", "elastics://localhost:9200", "http://localhost:9200", "https://localhost:9200"} {
+		_, err := es.NewElasticStore(uri)
+		require.NoError(t, err, uri)
+	}
+
+	// Unsupported schema
+	_, err := es.NewElasticStore("ftp://localhost:9200")
+	require.Error(t, err)
+
+	// API key and service token
+	_, err = es.NewElasticStore("elastics://localhost:9200?apikey=VnVhQ2ZHY0JDZGJrUW0tZTVhT3g6dWkybHAyYXhUTm1zeWFrdzl0dk5udw==")
+	require.NoError(t, err)
+
+	_, err = es.NewElasticStore("elastics://localhost:9200?service_token=AAEAAWVsYXN0aWMva2liYW5hL3Rva2VuMQ")
+	require.NoError(t, err)
+
+	// Valid cloud ID: <name>:base64(<host>$<es-uuid>$<kibana-uuid>)
+	_, err = es.NewElasticStore("elastics://?cloud_id=test:ZXUtd2VzdC0xLmF3cy5mb3VuZC5pbyRjZWM2ZjI2MWE3NGJmMjRjZTMzYmI4ODExYjg0Mjk0ZiRjNmMyY2E2ZDA0MjI0OWFmMGNjN2Q3YTllOTYyNTc0Mw==")
+	require.NoError(t, err)
+
+	// Invalid cloud ID
+	_, err = es.NewElasticStore("elastics://?cloud_id=invalid")
+	require.Error(t, err)
+}
+
+// recordingTransport is a fake HTTP transport which records the requests and returns an empty search result
+type recordingTransport struct {
+	paths []string
+}
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.paths = append(rt.paths, req.URL.Path)
+	header := http.Header{}
+	header.Set("X-Elastic-Product", "Elasticsearch")
+	header.Set("Content-Type", "application/json")
+	body := `{"took":1,"timed_out":false,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0},"hits":{"total":{"value":0,"relation":"eq"},"hits":[]}}`
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func TestStoreWithOptions(t *testing.T) {
+
+	transport := &recordingTransport{}
+	store, err := es.NewElasticStoreWithOptions(
+		es.WithHosts("http://localhost:9200"),
+		es.WithTransport(transport),
+		es.WithIndexPrefix("dev-"),
+		es.WithMaxRetries(2),
+		es.WithCompression(true),
+		es.WithBulkOptions(es.BulkOptions{NumWorkers: 2, FlushInterval: time.Second}),
+	)
+	require.NoError(t, err)
+
+	exists, err := store.Exists(NewHero, "1", "m")
+	require.NoError(t, err)
+	require.False(t, exists)
+	require.Equal(t, []string{"/dev-hero-m-*/_search"}, transport.paths)
+
+	// Clone keeps the options
+	clone, err := store.CloneDatastore()
+	require.NoError(t, err)
+	_, err = clone.Exists(NewHero, "1")
+	require.NoError(t, err)
+	require.Equal(t, "/dev-hero-*/_search", transport.paths[1])
+
+	// Invalid options
+	_, err = es.NewElasticStoreWithOptions(es.WithMaxRetries(-1))
+	require.Error(t, err)
+	_, err = es.NewElasticStoreWithOptions(es.WithURI("ftp://localhost"))
+	require.Error(t, err)
+}
+
+func TestStoreRequestTimeout(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	}))
+	defer server.Close()
+
+	store, err := es.NewElasticStoreWithOptions(
+		es.WithHosts(server.URL),
+		es.WithRequestTimeout(100*time.Millisecond),
+		es.WithDisableRetry(),
+	)
+	require.NoError(t, err)
+
+	start := time.Now()
+	err = store.Ping(1, 0)
+	require.Error(t, err)
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestCloudIDFromEnvironment(t *testing.T) {
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	// The environment cloud ID does not replace an explicit URI host
+	t.Setenv("ELASTIC_CLOUD_ID", "test:ZXUtd2VzdC0xLmF3cy5mb3VuZC5pbyRjZWM2ZjI2MWE3NGJmMjRjZTMzYmI4ODExYjg0Mjk0ZiRjNmMyY2E2ZDA0MjI0OWFmMGNjN2Q3YTllOTYyNTc0Mw==")
+	store, err := es.NewElasticStore(strings.Replace(server.URL, "http://", "elastic://", 1))
+	require.NoError(t, err)
+	require.NoError(t, store.Ping(1, 0))
+	require.Equal(t, 1, requests)
+
+	// The environment cloud ID is applied when the URI has no host
+	t.Setenv("ELASTIC_CLOUD_ID", "invalid")
+	_, err = es.NewElasticStore("elastics://")
+	require.Error(t, err)
+	_, err = es.NewElasticStore("elastics://localhost:9200")
+	require.NoError(t, err)
+}
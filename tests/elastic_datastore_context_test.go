@@ -0,0 +1,33 @@
+// Test context propagation of the elasticsearch datastore
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	es "github.com/go-yaaf/yaaf-common-elasticsearch/elasticsearch"
+)
+
+func TestStoreWithCanceledContext(t *testing.T) {
+
+	datastore, err := es.NewElasticStore("elastic://localhost:1")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	store := datastore.(*es.ElasticStore).WithContext(ctx)
+	require.Equal(t, ctx, store.Context())
+
+	start := time.Now()
+	err = store.Ping(5, 10)
+	require.True(t, errors.Is(err, context.Canceled))
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	_, _, err = store.Query(NewHero).Find()
+	require.Error(t, err)
+}
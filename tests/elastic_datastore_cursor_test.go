@@ -0,0 +1,184 @@
+// Test cursor based pagination (point in time and search_after)
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/go-yaaf/yaaf-common/entity"
+	"github.com/stretchr/testify/require"
+
+	es "github.com/go-yaaf/yaaf-common-elasticsearch/elasticsearch"
+)
+
+func TestFindAfter(t *testing.T) {
+
+	var requests []string
+	var bodies []string
+	expired := false
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		requests = append(requests, req.Method+" "+req.URL.Path)
+		body := ""
+		if req.Body != nil {
+			data, _ := io.ReadAll(req.Body)
+			body = string(data)
+		}
+		bodies = append(bodies, body)
+
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/_pit") && req.Method == http.MethodPost:
+			require.Equal(t, "30000ms", req.URL.Query().Get("keep_alive"))
+			return http.StatusOK, `{"id":"pit-1"}`
+		case req.URL.Path == "/_pit" && req.Method == http.MethodDelete:
+			return http.StatusOK, `{"succeeded":true,"num_freed":1}`
+		case expired:
+			return http.StatusNotFound, `{"error":{"root_cause":[{"type":"search_context_missing_exception","reason":"No search context found for id [1]"}],
+				"type":"search_phase_execution_exception","reason":"all shards failed"},"status":404}`
+		case strings.Contains(body, `"search_after"`):
+			return http.StatusOK, `{"took":1,"timed_out":false,"pit_id":"pit-3","_shards":{"total":1,"successful":1,"skipped":0,"failed":0},
+				"hits":{"total":{"value":3,"relation":"eq"},"hits":[
+				{"_index":"hero-m-2026.10","_id":"3","_score":null,"_source":{"id":"3","name":"Batman"},"sort":[3,12]}]}}`
+		default:
+			return http.StatusOK, `{"took":1,"timed_out":false,"pit_id":"pit-2","_shards":{"total":1,"successful":1,"skipped":0,"failed":0},
+				"hits":{"total":{"value":3,"relation":"eq"},"hits":[
+				{"_index":"hero-m-2026.10","_id":"1","_score":null,"_source":{"id":"1","name":"Ironman"},"sort":[1,10]},
+				{"_index":"hero-m-2026.10","_id":"2","_score":null,"_source":{"id":"2","name":"Superman"},"sort":[2,11]}]}}`
+		}
+	})
+
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport))
+	require.NoError(t, err)
+
+	query := store.ElasticQuery(NewHero).KeepAlive(30 * time.Second)
+	query.Sort("num").Limit(2)
+
+	// First page opens the point in time
+	list, cursor, err := query.FindAfter("", "m")
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	require.NotEmpty(t, cursor)
+	require.Equal(t, "POST /hero-m-*/_pit", requests[0])
+	require.Equal(t, "POST /_search", requests[1])
+	require.Contains(t, bodies[1], `"pit":{"id":"pit-1","keep_alive":"30000ms"}`)
+	require.Contains(t, bodies[1], `{"_shard_doc":{"order":"asc"}}`)
+
+	// Last page closes the point in time
+	list, next, err := query.FindAfter(cursor, "m")
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.Equal(t, "Batman", list[0].(*Hero).Name)
+	require.Empty(t, next)
+	require.Contains(t, bodies[2], `"pit":{"id":"pit-2"`)
+	require.Contains(t, bodies[2], `"search_after":[2,11]`)
+	require.Equal(t, "DELETE /_pit", requests[3])
+	require.Contains(t, bodies[3], `"id":"pit-3"`)
+
+	// Expired cursor
+	expired = true
+	_, _, err = query.FindAfter(cursor, "m")
+	require.True(t, errors.Is(err, es.ErrCursorExpired))
+	require.NoError(t, store.CloseCursor(cursor))
+
+	_, _, err = query.FindAfter("invalid", "m")
+	require.ErrorContains(t, err, "invalid cursor")
+}
+
+func TestStream(t *testing.T) {
+
+	var requests []string
+	pages := 0
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		requests = append(requests, req.Method+" "+req.URL.Path)
+		switch {
+		case req.URL.Path == "/hero-m-*/_pit":
+			return http.StatusOK, `{"id":"pit-1"}`
+		case req.URL.Path == "/_pit":
+			return http.StatusOK, `{"succeeded":true,"num_freed":1}`
+		}
+		pages++
+		if pages == 3 {
+			return http.StatusOK, `{"took":1,"timed_out":false,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0},
+				"hits":{"total":{"value":5,"relation":"eq"},"hits":[
+				{"_index":"hero-m-2026.10","_id":"5","_score":null,"_source":{"id":"5","num":5},"sort":[5]}]}}`
+		}
+		return http.StatusOK, fmt.Sprintf(`{"took":1,"timed_out":false,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0},
+			"hits":{"total":{"value":5,"relation":"eq"},"hits":[
+			{"_index":"hero-m-2026.10","_id":"%[1]d","_score":null,"_source":{"id":"%[1]d","num":%[1]d},"sort":[%[1]d]},
+			{"_index":"hero-m-2026.10","_id":"%[2]d","_score":null,"_source":{"id":"%[2]d","num":%[2]d},"sort":[%[2]d]}]}}`, 2*pages-1, 2*pages)
+	})
+
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport))
+	require.NoError(t, err)
+
+	query := store.ElasticQuery(NewHero)
+	query.Limit(2).Apply(func(in Entity) Entity {
+		if in.(*Hero).Num == 3 {
+			return nil
+		}
+		return in
+	})
+
+	// Walk all the pages, the callbacks are applied
+	nums := make([]int, 0)
+	for entity, er := range query.Stream(context.Background(), "m") {
+		require.NoError(t, er)
+		nums = append(nums, entity.(*Hero).Num)
+	}
+	require.Equal(t, []int{1, 2, 4, 5}, nums)
+	require.Equal(t, "DELETE /_pit", requests[len(requests)-1])
+
+	// Break closes the point in time
+	pages, requests = 0, nil
+	for range query.Stream(context.Background(), "m") {
+		break
+	}
+	require.Equal(t, []string{"POST /hero-m-*/_pit", "POST /_search", "DELETE /_pit"}, requests)
+
+	// Canceled context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, er := range query.Stream(ctx, "m") {
+		require.ErrorIs(t, er, context.Canceled)
+	}
+}
+
+func TestStreamPageSize(t *testing.T) {
+
+	var sizes []string
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		switch {
+		case req.URL.Path == "/hero-m-*/_pit":
+			return http.StatusOK, `{"id":"pit-1"}`
+		case req.URL.Path == "/_pit":
+			return http.StatusOK, `{"succeeded":true,"num_freed":1}`
+		}
+		data, _ := io.ReadAll(req.Body)
+		if _, after, ok := strings.Cut(string(data), `"size":`); ok {
+			sizes = append(sizes, strings.SplitN(after, ",", 2)[0])
+		}
+		return http.StatusOK, `{"took":1,"timed_out":false,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0},
+			"hits":{"total":{"value":0,"relation":"eq"},"hits":[]}}`
+	})
+
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport))
+	require.NoError(t, err)
+
+	// Default page sizes of the stream and the cursor
+	for range store.ElasticQuery(NewHero).Stream(context.Background(), "m") {
+	}
+	_, _, err = store.ElasticQuery(NewHero).FindAfter("", "m")
+	require.NoError(t, err)
+
+	// Explicit page size
+	query := store.ElasticQuery(NewHero)
+	query.Limit(50)
+	for range query.Stream(context.Background(), "m") {
+	}
+	require.Equal(t, []string{"1000", "100", "50"}, sizes)
+}
@@ -0,0 +1,81 @@
+// Test elasticsearch errors classification
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	est "github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/stretchr/testify/require"
+
+	es "github.com/go-yaaf/yaaf-common-elasticsearch/elasticsearch"
+)
+
+func newElasticsearchError(status int, errType, reason string) error {
+	ee := est.NewElasticsearchError()
+	ee.Status = status
+	ee.ErrorCause.Type = errType
+	ee.ErrorCause.Reason = &reason
+	ee.ErrorCause.Metadata = map[string]json.RawMessage{"index": json.RawMessage(`"hero-m-2026.10"`)}
+	return ee
+}
+
+func TestElasticErrorClassification(t *testing.T) {
+
+	cases := []struct {
+		err  error
+		kind error
+	}{
+		{newElasticsearchError(http.StatusNotFound, "index_not_found_exception", "no such index [hero-m-2026.10]"), es.ErrIndexNotFound},
+		{newElasticsearchError(http.StatusNotFound, "document_missing_exception", "[1]: document missing"), es.ErrNotFound},
+		{newElasticsearchError(http.StatusConflict, "version_conflict_engine_exception", "[1]: version conflict"), es.ErrVersionConflict},
+		{newElasticsearchError(http.StatusTooManyRequests, "es_rejected_execution_exception", "rejected execution"), es.ErrTooManyRequests},
+		{newElasticsearchError(http.StatusBadRequest, "mapper_parsing_exception", "failed to parse field [num]"), es.ErrMappingConflict},
+		{newElasticsearchError(http.StatusBadRequest, "illegal_argument_exception", "mapper [num] cannot be changed from type [long] to [text]"), es.ErrMappingConflict},
+		{newElasticsearchError(http.StatusGatewayTimeout, "", "gateway timeout"), es.ErrTimeout},
+		{fmt.Errorf("request failed: %w", context.DeadlineExceeded), es.ErrTimeout},
+	}
+
+	for _, c := range cases {
+		err := es.ElasticError(c.err)
+		require.True(t, errors.Is(err, c.kind), "%s should be %s", err, c.kind)
+
+		// The original error is preserved
+		require.True(t, errors.Is(err, c.err) || errors.Unwrap(err) == c.err)
+	}
+
+	// Details of the elasticsearch error
+	err := es.ElasticError(newElasticsearchError(http.StatusConflict, "version_conflict_engine_exception", "[1]: version conflict"))
+	var se *es.StoreError
+	require.True(t, errors.As(err, &se))
+	require.Equal(t, http.StatusConflict, se.Status)
+	require.Equal(t, "version_conflict_engine_exception", se.Type)
+	require.Equal(t, "hero-m-2026.10", se.Index)
+	require.Equal(t, "[1]: version conflict", se.Error())
+
+	var ee *est.ElasticsearchError
+	require.True(t, errors.As(err, &ee))
+
+	// Unclassified errors
+	require.Nil(t, es.ElasticError(nil))
+	plain := errors.New("plain error")
+	require.Equal(t, plain, es.ElasticError(plain))
+	require.False(t, errors.Is(es.ElasticError(newElasticsearchError(http.StatusBadRequest, "parsing_exception", "bad query")), es.ErrNotFound))
+}
+
+func TestGetNotFoundError(t *testing.T) {
+
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(&recordingTransport{}))
+	require.NoError(t, err)
+
+	_, err = store.Get(NewHero, "1")
+	require.True(t, errors.Is(err, es.ErrNotFound))
+
+	exists, err := store.Exists(NewHero, "1")
+	require.NoError(t, err)
+	require.False(t, exists)
+}
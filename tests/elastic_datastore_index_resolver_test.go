@@ -0,0 +1,155 @@
+// Test index resolver
+package test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/go-yaaf/yaaf-common/entity"
+	"github.com/stretchr/testify/require"
+
+	es "github.com/go-yaaf/yaaf-common-elasticsearch/elasticsearch"
+)
+
+func TestDefaultIndexResolver(t *testing.T) {
+
+	r := es.DefaultIndexResolver{}
+	ts := time.Date(2026, time.January, 2, 10, 0, 0, 0, time.UTC)
+
+	writes := []struct {
+		table string
+		keys  []string
+		index string
+	}{
+		{"hero-{accountId}-{year}.{month}", []string{"acme"}, "hero-acme-2026.01"},
+		{"hero-{accountId}-{YYYY.MM}", []string{"acme"}, "hero-acme-2026.01"},
+		{"logs-{YYYY}.{MM}.{DD}", nil, "logs-2026.01.02"},
+		{"logs-{year}-w{week}", nil, "logs-2026-w01"},
+		{"logs-{year}-q{quarter}", nil, "logs-2026-q1"},
+		{"events-{accountId}-{region}-{day}", []string{"acme", "eu"}, "events-acme-eu-02"},
+		{"heroes", []string{"acme"}, "heroes"},
+	}
+	for _, w := range writes {
+		require.Equal(t, w.index, r.WriteIndex(w.table, ts, w.keys...), w.table)
+	}
+
+	reads := []struct {
+		table   string
+		keys    []string
+		pattern string
+	}{
+		{"hero-{accountId}-{year}.{month}", []string{"acme"}, "hero-acme-*"},
+		{"hero-{accountId}-{year}.{month}", nil, "hero-*"},
+		{"events-{accountId}-{region}-{day}", []string{"acme"}, "events-acme-*"},
+		{"events-{accountId}-{region}", []string{"acme", "eu"}, "events-acme-eu"},
+		{"heroes", nil, "heroes"},
+		{"hero", []string{"acme"}, "hero"},
+	}
+	for _, rd := range reads {
+		require.Equal(t, rd.pattern, r.ReadPattern(rd.table, rd.keys...), rd.table)
+	}
+
+	require.Equal(t, "hero-*", r.TemplatePattern("hero-{accountId}-{year}.{month}"))
+	require.True(t, r.Partitioned("hero-{accountId}-{year}.{month}", "acme"))
+	require.True(t, r.Partitioned("events-{accountId}-{region}", "acme"))
+	require.False(t, r.Partitioned("events-{accountId}-{region}", "acme", "eu"))
+	require.False(t, r.Partitioned("heroes"))
+}
+
+// flatResolver writes all the tables to a single index per table
+type flatResolver struct{}
+
+func (flatResolver) WriteIndex(table string, _ time.Time, _ ...string) string {
+	return "flat_" + table[:strings.Index(table, "-")]
+}
+func (flatResolver) ReadPattern(table string, _ ...string) string {
+	return "flat_" + table[:strings.Index(table, "-")]
+}
+func (flatResolver) TemplatePattern(table string) string {
+	return "flat_" + table[:strings.Index(table, "-")]
+}
+func (flatResolver) Partitioned(_ string, _ ...string) bool { return false }
+
+func TestCustomIndexResolver(t *testing.T) {
+
+	var paths []string
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		paths = append(paths, req.Method+" "+req.URL.Path)
+		return http.StatusOK, `{"_index":"flat_hero","_id":"1","_version":2,"result":"updated","_seq_no":1,"_primary_term":1,
+			"_shards":{"total":1,"successful":1,"failed":0}}`
+	})
+
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport),
+		es.WithIndexResolver(flatResolver{}), es.WithIndexPrefix("qa_"))
+	require.NoError(t, err)
+
+	_, err = store.Insert(NewHero1("1", 1, "Ironman", "Marvel", "red"))
+	require.NoError(t, err)
+
+	// Not partitioned, the index is not resolved by search
+	require.NoError(t, store.SetField(NewHero, "1", "name", "Ironman", "m"))
+	require.Equal(t, []string{"PUT /qa_flat_hero/_doc/1", "POST /qa_flat_hero/_update/1"}, paths)
+
+	_, err = es.NewElasticStoreWithOptions(es.WithIndexResolver(nil))
+	require.Error(t, err)
+}
+
+func TestRangeIndices(t *testing.T) {
+
+	from := time.Date(2024, time.January, 15, 0, 0, 0, 0, time.Local)
+	to := time.Date(2024, time.March, 3, 0, 0, 0, 0, time.Local)
+
+	r := es.DefaultIndexResolver{}
+	indices, ok := r.RangeIndices("hero-{accountId}-{year}.{month}", from, to, "m")
+	require.True(t, ok)
+	require.Equal(t, []string{"hero-m-2024.01", "hero-m-2024.02", "hero-m-2024.03"}, indices)
+
+	indices, ok = r.RangeIndices("logs-{YYYY}.{MM}.{DD}", to.AddDate(0, 0, -2), to)
+	require.True(t, ok)
+	require.Equal(t, []string{"logs-2024.03.01", "logs-2024.03.02", "logs-2024.03.03"}, indices)
+
+	// Missing shard key or no time template
+	_, ok = r.RangeIndices("hero-{accountId}-{year}.{month}", from, to)
+	require.False(t, ok)
+	_, ok = r.RangeIndices("heroes", from, to)
+	require.False(t, ok)
+
+	var paths []string
+	var queries []string
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		paths = append(paths, req.URL.Path)
+		queries = append(queries, req.URL.RawQuery)
+		if strings.HasSuffix(req.URL.Path, "/_count") {
+			return http.StatusOK, `{"count":0,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0}}`
+		}
+		return http.StatusOK, `{"took":1,"timed_out":false,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0},"hits":{"total":{"value":0,"relation":"eq"},"hits":[]}}`
+	})
+
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport))
+	require.NoError(t, err)
+
+	// Without index time field the documents are written by the current time, the range is not narrowed
+	_, err = store.Query(NewHero).Range("createdOn", Timestamp(from.UnixMilli()), Timestamp(to.UnixMilli())).Count("m")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/hero-m-*/_count"}, paths)
+
+	paths, queries = nil, nil
+	store, err = es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport),
+		es.WithIndexTimeField("createdOn"))
+	require.NoError(t, err)
+
+	// Range on the index time field
+	_, _, err = store.Query(NewHero).Range("createdOn", Timestamp(from.UnixMilli()), Timestamp(to.UnixMilli())).Limit(10).Find("m")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/hero-m-2024.01,hero-m-2024.02,hero-m-2024.03/_count", "/hero-m-2024.01,hero-m-2024.02,hero-m-2024.03/_search"}, paths)
+	require.Contains(t, queries[0], "ignore_unavailable=true")
+	require.Contains(t, queries[1], "ignore_unavailable=true")
+
+	// Range on other field
+	paths = nil
+	_, err = store.Query(NewHero).Range("updatedOn", Timestamp(from.UnixMilli()), Timestamp(to.UnixMilli())).Count("m")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/hero-m-*/_count"}, paths)
+}
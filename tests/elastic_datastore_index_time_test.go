@@ -0,0 +1,156 @@
+// Test index routing by the entity time
+package test
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/go-yaaf/yaaf-common/entity"
+	"github.com/stretchr/testify/require"
+
+	es "github.com/go-yaaf/yaaf-common-elasticsearch/elasticsearch"
+)
+
+// eventHero provides the index time
+type eventHero struct {
+	*Hero
+	EventTime Timestamp `json:"eventTime"`
+}
+
+func (h *eventHero) IndexTime() Timestamp { return h.EventTime }
+
+func TestIndexTime(t *testing.T) {
+
+	var mu sync.Mutex
+	var paths []string
+	var lines []string
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		paths = append(paths, req.Method+" "+req.URL.Path)
+		if strings.HasSuffix(req.URL.Path, "/_bulk") {
+			lines = append(lines, bulkLines(req)...)
+			return http.StatusOK, bulkResponse(req, func(id string) int { return http.StatusCreated })
+		}
+		return http.StatusCreated, `{"_index":"hero","_id":"1","_version":1,"result":"created","_seq_no":1,"_primary_term":1,
+			"_shards":{"total":1,"successful":1,"failed":0}}`
+	})
+
+	march := time.Date(2024, time.March, 15, 12, 0, 0, 0, time.Local)
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport), es.WithIndexTimeField("createdOn"))
+	require.NoError(t, err)
+
+	// Configured time field
+	hero := NewHero1("1", 1, "Ironman", "Marvel", "red").(*Hero)
+	hero.CreatedOn = Timestamp(march.UnixMilli())
+	_, err = store.Insert(hero)
+	require.NoError(t, err)
+	require.Equal(t, "PUT /hero-m-2024.03/_doc/1", paths[0])
+
+	// Time provider takes precedence
+	event := &eventHero{Hero: NewHero1("2", 2, "Superman", "DC", "blue").(*Hero), EventTime: Timestamp(march.AddDate(0, -2, 0).UnixMilli())}
+	event.CreatedOn = Timestamp(march.UnixMilli())
+	_, err = store.Insert(event)
+	require.NoError(t, err)
+	require.Equal(t, "PUT /hero-m-2024.01/_doc/2", paths[1])
+
+	// Missing time falls back to the current time
+	hero.CreatedOn = 0
+	_, err = store.BulkInsertWithResult([]Entity{hero, event}, es.BulkWorkers(1))
+	require.NoError(t, err)
+	require.Contains(t, lines[0], `"_index":"hero-m-`+time.Now().Format("2006.01")+`"`)
+	require.Contains(t, lines[2], `"_index":"hero-m-2024.01"`)
+}
+
+// loggedHero has the index time field as RFC3339 string
+type loggedHero struct {
+	*Hero
+	LoggedAt string `json:"loggedAt"`
+}
+
+// seenHero has the index time field as time.Time
+type seenHero struct {
+	Hero
+	SeenAt *time.Time `json:"loggedAt,omitempty"`
+}
+
+func TestIndexTimeFieldTypes(t *testing.T) {
+
+	var paths []string
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		paths = append(paths, req.Method+" "+req.URL.Path)
+		return http.StatusCreated, `{"_index":"hero","_id":"1","_version":1,"result":"created","_seq_no":1,"_primary_term":1,
+			"_shards":{"total":1,"successful":1,"failed":0}}`
+	})
+
+	march := time.Date(2024, time.March, 15, 12, 0, 0, 0, time.Local)
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport), es.WithIndexTimeField("loggedAt"))
+	require.NoError(t, err)
+
+	// RFC3339 string
+	logged := &loggedHero{Hero: NewHero1("1", 1, "Ironman", "Marvel", "red").(*Hero), LoggedAt: march.Format(time.RFC3339)}
+	_, err = store.Insert(logged)
+	require.NoError(t, err)
+	require.Equal(t, "PUT /hero-m-2024.03/_doc/1", paths[0])
+
+	// Time value, nil falls back to the current time
+	seen := &seenHero{Hero: *NewHero1("2", 2, "Superman", "DC", "blue").(*Hero)}
+	_, err = store.Insert(seen)
+	require.NoError(t, err)
+	require.Equal(t, "PUT /hero-m-"+time.Now().Format("2006.01")+"/_doc/2", paths[1])
+
+	january := march.AddDate(0, -2, 0)
+	seen.SeenAt = &january
+	_, err = store.Insert(seen)
+	require.NoError(t, err)
+	require.Equal(t, "PUT /hero-m-2024.01/_doc/2", paths[2])
+}
+
+func TestUpsertByIndexTime(t *testing.T) {
+
+	var mu sync.Mutex
+	var paths []string
+	var lines []string
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		paths = append(paths, req.Method+" "+req.URL.Path)
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/_search"):
+			return http.StatusOK, `{"took":1,"timed_out":false,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0},
+				"hits":{"total":{"value":0,"relation":"eq"},"hits":[]}}`
+		case strings.HasSuffix(req.URL.Path, "/_bulk"):
+			lines = append(lines, bulkLines(req)...)
+			return http.StatusOK, bulkResponse(req, func(id string) int { return http.StatusOK })
+		}
+		return http.StatusOK, `{"_index":"hero","_id":"1","_version":1,"result":"updated","_seq_no":1,"_primary_term":1,
+			"_shards":{"total":1,"successful":1,"failed":0}}`
+	})
+
+	march := time.Date(2024, time.March, 15, 12, 0, 0, 0, time.Local)
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport), es.WithIndexTimeField("createdOn"))
+	require.NoError(t, err)
+
+	// The entity time resolves the index without searching the document
+	hero := NewHero1("1", 1, "Ironman", "Marvel", "red").(*Hero)
+	hero.CreatedOn = Timestamp(march.UnixMilli())
+	_, err = store.Upsert(hero)
+	require.NoError(t, err)
+	require.Equal(t, []string{"POST /hero-m-2024.03/_update/1"}, paths)
+
+	_, err = store.BulkUpsertWithResult([]Entity{hero}, es.BulkWorkers(1))
+	require.NoError(t, err)
+	require.True(t, strings.HasSuffix(paths[1], "/_bulk"))
+	require.Len(t, paths, 2)
+	require.Contains(t, lines[0], `"_index":"hero-m-2024.03"`)
+
+	// Without entity time the document is searched
+	paths = nil
+	hero.CreatedOn = 0
+	_, err = store.Upsert(hero)
+	require.NoError(t, err)
+	require.Equal(t, []string{"POST /hero-m-*/_search", "POST /hero-m-" + time.Now().Format("2006.01") + "/_update/1"}, paths)
+}
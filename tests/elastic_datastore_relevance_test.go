@@ -0,0 +1,91 @@
+// Test relevance scored search
+package test
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-yaaf/yaaf-common/database"
+	"github.com/stretchr/testify/require"
+
+	es "github.com/go-yaaf/yaaf-common-elasticsearch/elasticsearch"
+)
+
+func TestRelevanceQuery(t *testing.T) {
+
+	body := ""
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		data, _ := io.ReadAll(req.Body)
+		body = string(data)
+		return http.StatusOK, `{"took":1,"timed_out":false,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0},
+			"hits":{"total":{"value":2,"relation":"eq"},"max_score":3.5,"hits":[
+			{"_index":"hero-m-2026.10","_id":"1","_score":3.5,"_source":{"id":"1","name":"Iron Man"}},
+			{"_index":"hero-m-2026.10","_id":"2","_score":1.25,"_source":{"id":"2","name":"Iron Fist"}}]}}`
+	})
+
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport))
+	require.NoError(t, err)
+
+	// Should clauses with boosts, recency decay and field value factor
+	query := store.ElasticQuery(NewHero).
+		MatchText(es.Match("name", "iron")).
+		Should(es.MatchPhrase("name", "iron man").Boost(3), es.ScoredFilter(database.F("type").Eq("Marvel")).Boost(2)).
+		ScoreWith(es.RecencyBoost("createdOn", 7*24*time.Hour, 0.5), es.FieldValueFactor("strength", 1.2, "log1p").Missing(1)).
+		TotalHits(es.TotalHitsExact)
+
+	list, total, err := query.FindScored("m")
+	require.NoError(t, err)
+	require.Equal(t, int64(2), total.Value)
+	require.Len(t, list, 2)
+	require.Equal(t, 3.5, list[0].Score)
+	require.Equal(t, "Iron Man", list[0].Entity.(*Hero).Name)
+	require.Equal(t, 1.25, list[1].Score)
+	require.JSONEq(t, `{"query":{"function_score":{
+		"query":{"bool":{
+			"must":[{"match":{"name":{"query":"iron"}}}],
+			"should":[{"match_phrase":{"name":{"boost":3,"query":"iron man"}}},
+				{"constant_score":{"boost":2,"filter":{"term":{"type":{"value":"Marvel"}}}}}],
+			"minimum_should_match":0}},
+		"functions":[
+			{"gauss":{"createdOn":{"origin":"now","scale":"604800000ms","decay":0.5}}},
+			{"field_value_factor":{"field":"strength","factor":1.2,"modifier":"log1p","missing":1}}]}},
+		"size":100,"from":0,"track_total_hits":true}`, body)
+
+	// Scores are tracked when sorted by field
+	query = store.ElasticQuery(NewHero).Should(es.Match("name", "iron")).TotalHits(es.TotalHitsNone)
+	query.Sort("num")
+	_, _, err = query.FindScored("m")
+	require.NoError(t, err)
+	require.Contains(t, body, `"track_scores":true`)
+
+	// Invalid decay
+	_, _, err = store.ElasticQuery(NewHero).ScoreWith(es.RecencyBoost("createdOn", time.Hour, 2)).FindScored("m")
+	require.ErrorContains(t, err, "decay")
+}
+
+func TestRelevanceFilterOnly(t *testing.T) {
+
+	body := ""
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		data, _ := io.ReadAll(req.Body)
+		body = string(data)
+		return http.StatusOK, `{"took":1,"timed_out":false,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0},
+			"hits":{"total":{"value":0,"relation":"eq"},"hits":[]}}`
+	})
+
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport))
+	require.NoError(t, err)
+
+	// Filters score 0, the functions score replaces the query score
+	query := store.ElasticQuery(NewHero).ScoreWith(es.FieldValueFactor("strength", 1, "")).TotalHits(es.TotalHitsNone)
+	query.MatchAll(database.F("type").Eq("Marvel"), database.F("color").Eq("red"))
+	_, _, err = query.FindScored("m")
+	require.NoError(t, err)
+	require.JSONEq(t, `{"query":{"function_score":{
+		"query":{"bool":{"filter":[{"term":{"type":{"value":"Marvel"}}},{"term":{"color":{"value":"red"}}}]}},
+		"functions":[{"field_value_factor":{"field":"strength","factor":1}}],
+		"boost_mode":"replace"}},
+		"size":100,"from":0,"track_total_hits":false}`, body)
+}
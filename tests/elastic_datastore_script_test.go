@@ -0,0 +1,71 @@
+// Test scripted updates
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	. "github.com/go-yaaf/yaaf-common/database"
+	"github.com/stretchr/testify/require"
+
+	es "github.com/go-yaaf/yaaf-common-elasticsearch/elasticsearch"
+)
+
+func TestScriptedUpdates(t *testing.T) {
+
+	var paths []string
+	var body map[string]any
+	conflicts := false
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		paths = append(paths, req.URL.Path)
+		if req.Body != nil {
+			data, _ := io.ReadAll(req.Body)
+			body = map[string]any{}
+			_ = json.Unmarshal(data, &body)
+		}
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/_search"):
+			return http.StatusOK, `{"took":1,"timed_out":false,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0},
+				"hits":{"total":{"value":1,"relation":"eq"},"hits":[{"_index":"hero-m-2024.01","_id":"1","_score":1,"_source":{"id":"1","key":"m"}}]}}`
+		case strings.HasSuffix(req.URL.Path, "/_update_by_query") && conflicts:
+			return http.StatusOK, `{"took":5,"timed_out":false,"total":3,"updated":2,"deleted":0,"batches":1,"version_conflicts":1,"noops":0,
+				"failures":[{"index":"hero-m-2024.01","id":"3","status":409,"cause":{"type":"version_conflict_engine_exception","reason":"[3]: version conflict"}}]}`
+		case strings.HasSuffix(req.URL.Path, "/_update_by_query"):
+			return http.StatusOK, `{"took":5,"timed_out":false,"total":3,"updated":3,"deleted":0,"batches":1,"version_conflicts":0,"noops":0,"failures":[]}`
+		default:
+			return http.StatusOK, `{"_index":"hero-m-2024.01","_id":"1","_version":2,"result":"updated","_seq_no":8,"_primary_term":2,
+				"_shards":{"total":1,"successful":1,"failed":0}}`
+		}
+	})
+
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport))
+	require.NoError(t, err)
+
+	// Single document update
+	err = store.UpdateWithScript(NewHero, "1", es.IncrementScript("num", 2), "m")
+	require.NoError(t, err)
+	require.Equal(t, "/hero-m-2024.01/_update/1", paths[1])
+	script := body["script"].(map[string]any)
+	require.Contains(t, script["source"], "+= params.delta")
+	require.Equal(t, map[string]any{"field": "num", "delta": float64(2)}, script["params"])
+
+	err = store.UpdateWithScript(NewHero, "1", es.Script{}, "m")
+	require.Error(t, err)
+
+	// Update by query
+	query := store.ElasticQuery(NewHero).Filter(F("color").Eq("red")).(es.IElasticQuery)
+	updated, err := query.UpdateWithScript(es.AddToSetScript("tags", "hero", "marvel"), "m")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), updated)
+	require.Equal(t, "/hero-m-*/_update_by_query", paths[len(paths)-1])
+	require.NotNil(t, body["query"])
+
+	conflicts = true
+	updated, err = query.UpdateWithScript(es.SetIfGreaterScript("strength", 90), "m")
+	require.True(t, errors.Is(err, es.ErrVersionConflict))
+	require.Equal(t, int64(2), updated)
+}
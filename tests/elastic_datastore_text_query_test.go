@@ -0,0 +1,57 @@
+// Test full-text queries
+package test
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/go-yaaf/yaaf-common/database"
+	"github.com/stretchr/testify/require"
+
+	es "github.com/go-yaaf/yaaf-common-elasticsearch/elasticsearch"
+)
+
+func TestTextQuery(t *testing.T) {
+
+	body := ""
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		data, _ := io.ReadAll(req.Body)
+		body = string(data)
+		return http.StatusOK, `{"took":1,"timed_out":false,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0},
+			"hits":{"total":{"value":1,"relation":"eq"},"max_score":2.5,"hits":[
+			{"_index":"hero-m-2026.10","_id":"1","_score":2.5,"_source":{"id":"1","name":"Iron Man"}}]}}`
+	})
+
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport))
+	require.NoError(t, err)
+
+	queries := []struct {
+		query es.TextQuery
+		dsl   string
+	}{
+		{es.Match("name", "iron man").And(), `{"match":{"name":{"operator":"and","query":"iron man"}}}`},
+		{es.Match("name", "iron man hero").MinimumShouldMatch("75%"), `{"match":{"name":{"minimum_should_match":"75%","query":"iron man hero"}}}`},
+		{es.MatchPhrase("name", "iron man").Slop(1), `{"match_phrase":{"name":{"query":"iron man","slop":1}}}`},
+		{es.MatchPhrasePrefix("name", "iron m"), `{"match_phrase_prefix":{"name":{"query":"iron m"}}}`},
+		{es.MultiMatch("iron", "name^3", "type").Type("most_fields"), `{"multi_match":{"fields":["name^3","type"],"query":"iron","type":"most_fields"}}`},
+		{es.Fuzzy("name", "irnman").Boost(2), `{"fuzzy":{"name":{"boost":2,"fuzziness":"AUTO","value":"irnman"}}}`},
+		{es.SimpleQueryString(`iron -"bat man`, "name"), `{"simple_query_string":{"fields":["name"],"lenient":true,"query":"iron -\"bat man"}}`},
+	}
+	for _, q := range queries {
+		list, _, err := store.ElasticQuery(NewHero).TotalHits(es.TotalHitsNone).MatchText(q.query).Find("m")
+		require.NoError(t, err)
+		require.Len(t, list, 1)
+		require.JSONEq(t, `{"query":{"bool":{"must":[`+q.dsl+`]}},"size":100,"from":0,"track_total_hits":false}`, body)
+	}
+
+	// Text queries along with filters, any text query should match, empty text is ignored
+	query := store.ElasticQuery(NewHero)
+	query.Filter(database.F("type").Eq("Marvel"))
+	_, _, err = query.MatchAnyText(es.Match("name", "iron"), es.Match("name", "bat"), es.Match("color", " ")).
+		TotalHits(es.TotalHitsNone).Find("m")
+	require.NoError(t, err)
+	require.JSONEq(t, `{"query":{"bool":{
+		"must":[{"bool":{"minimum_should_match":1,"should":[{"match":{"name":{"query":"iron"}}},{"match":{"name":{"query":"bat"}}}]}}],
+		"filter":[{"term":{"type":{"value":"Marvel"}}}]}},"size":100,"from":0,"track_total_hits":false}`, body)
+}
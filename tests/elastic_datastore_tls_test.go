@@ -0,0 +1,115 @@
+// Test elasticsearch datastore TLS connection settings
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	es "github.com/go-yaaf/yaaf-common-elasticsearch/elasticsearch"
+)
+
+// newTLSServer starts a fake elasticsearch TLS server which answers every request with an empty JSON document
+func newTLSServer(t *testing.T, clientCAs *x509.CertPool) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	if clientCAs != nil {
+		server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newCertificate creates a certificate signed by the parent (self-signed if parent is nil), returns the certificate
+// and the PEM encoded certificate and key
+func newCertificate(t *testing.T, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return cert, key,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+// pingTLS connects to the server using the TLS options
+func pingTLS(t *testing.T, server *httptest.Server, opts es.TLSOptions) error {
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts(server.URL), es.WithTLS(opts), es.WithDisableRetry())
+	require.NoError(t, err)
+	return store.Ping(1, 0)
+}
+
+func TestTLSConnection(t *testing.T) {
+
+	server := newTLSServer(t, nil)
+	serverCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	digest := sha256.Sum256(server.Certificate().Raw)
+	fingerprint := hex.EncodeToString(digest[:])
+	_, _, otherCA, _ := newCertificate(t, nil, nil)
+
+	// CA trust
+	require.NoError(t, pingTLS(t, server, es.TLSOptions{CACert: serverCA}))
+	require.Error(t, pingTLS(t, server, es.TLSOptions{CACert: otherCA}))
+
+	// Fingerprint match and mismatch
+	require.NoError(t, pingTLS(t, server, es.TLSOptions{CertificateFingerprint: fingerprint}))
+	require.Error(t, pingTLS(t, server, es.TLSOptions{CertificateFingerprint: "AB:CD:EF:01"}))
+
+	// The CA is verified along with the fingerprint
+	require.NoError(t, pingTLS(t, server, es.TLSOptions{CACert: serverCA, CertificateFingerprint: fingerprint}))
+	require.Error(t, pingTLS(t, server, es.TLSOptions{CACert: otherCA, CertificateFingerprint: fingerprint}))
+}
+
+func TestTLSClientCertificate(t *testing.T) {
+
+	ca, caKey, _, _ := newCertificate(t, nil, nil)
+	_, _, clientCert, clientKey := newCertificate(t, ca, caKey)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+
+	server := newTLSServer(t, clientCAs)
+	serverCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	// The server requires a client certificate signed by its CA
+	require.NoError(t, pingTLS(t, server, es.TLSOptions{CACert: serverCA, ClientCert: clientCert, ClientKey: clientKey}))
+	require.Error(t, pingTLS(t, server, es.TLSOptions{CACert: serverCA}))
+
+	_, _, otherCert, otherKey := newCertificate(t, nil, nil)
+	require.Error(t, pingTLS(t, server, es.TLSOptions{CACert: serverCA, ClientCert: otherCert, ClientKey: otherKey}))
+}
@@ -0,0 +1,66 @@
+// Test total hits modes of Find
+package test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	es "github.com/go-yaaf/yaaf-common-elasticsearch/elasticsearch"
+)
+
+func TestTotalHits(t *testing.T) {
+
+	var paths []string
+	var bodies []string
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		paths = append(paths, req.URL.Path)
+		data, _ := io.ReadAll(req.Body)
+		bodies = append(bodies, string(data))
+		if strings.HasSuffix(req.URL.Path, "/_count") {
+			return http.StatusOK, `{"count":42,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0}}`
+		}
+		relation := "eq"
+		if strings.Contains(string(data), `"track_total_hits":10`) {
+			relation = "gte"
+		}
+		return http.StatusOK, `{"took":1,"timed_out":false,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0},
+			"hits":{"total":{"value":10,"relation":"` + relation + `"},"hits":[
+			{"_index":"hero-m-2026.10","_id":"1","_score":1,"_source":{"id":"1","name":"Ironman"}}]}}`
+	})
+
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport))
+	require.NoError(t, err)
+
+	// Default: separate count request
+	_, total, err := store.Query(NewHero).Limit(10).Find("m")
+	require.NoError(t, err)
+	require.Equal(t, int64(42), total)
+	require.Equal(t, []string{"/hero-m-*/_count", "/hero-m-*/_search"}, paths)
+
+	// Exact total from the search response
+	paths, bodies = nil, nil
+	list, hits, err := store.ElasticQuery(NewHero).TotalHits(es.TotalHitsExact).FindWithTotal("m")
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.Equal(t, es.TotalHits{Value: 10}, hits)
+	require.Equal(t, []string{"/hero-m-*/_search"}, paths)
+	require.Contains(t, bodies[0], `"track_total_hits":true`)
+
+	// Bounded total
+	paths, bodies = nil, nil
+	_, hits, err = store.ElasticQuery(NewHero).TotalHits(es.TotalHitsUpTo(10)).FindWithTotal("m")
+	require.NoError(t, err)
+	require.Equal(t, es.TotalHits{Value: 10, MoreThan: true}, hits)
+	require.Len(t, paths, 1)
+
+	// No total
+	paths, bodies = nil, nil
+	_, total, err = store.ElasticQuery(NewHero).TotalHits(es.TotalHitsNone).Find("m")
+	require.NoError(t, err)
+	require.Equal(t, int64(0), total)
+	require.Contains(t, bodies[0], `"track_total_hits":false`)
+}
@@ -0,0 +1,56 @@
+// Test atomic update operations
+package test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	es "github.com/go-yaaf/yaaf-common-elasticsearch/elasticsearch"
+)
+
+func TestAtomicUpdates(t *testing.T) {
+
+	var requests []string
+	var bodies []string
+	found := true
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		requests = append(requests, req.Method+" "+req.URL.Path+"?"+req.URL.RawQuery)
+		if req.Body != nil {
+			body, _ := io.ReadAll(req.Body)
+			bodies = append(bodies, string(body))
+		}
+		if strings.HasSuffix(req.URL.Path, "/_search") {
+			if !found {
+				return http.StatusOK, `{"took":1,"timed_out":false,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0},"hits":{"total":{"value":0,"relation":"eq"},"hits":[]}}`
+			}
+			return http.StatusOK, `{"took":1,"timed_out":false,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0},
+				"hits":{"total":{"value":1,"relation":"eq"},"hits":[{"_index":"hero-m-2024.01","_id":"1","_score":1,"_source":{"id":"1","key":"m"}}]}}`
+		}
+		return http.StatusOK, `{"_index":"hero-m-2024.01","_id":"1","_version":2,"result":"updated","_seq_no":8,"_primary_term":2,
+			"_shards":{"total":1,"successful":1,"failed":0}}`
+	})
+
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport), es.WithRetryOnConflict(5))
+	require.NoError(t, err)
+
+	// Existing document in a time partitioned table is updated in its own index
+	require.NoError(t, store.SetField(NewHero, "1", "name", "Ironman", "m"))
+	require.Equal(t, "POST /hero-m-2024.01/_update/1?retry_on_conflict=5", requests[1])
+	require.JSONEq(t, `{"doc":{"name":"Ironman"}}`, bodies[1])
+
+	_, err = store.Upsert(NewHero1("1", 1, "Ironman", "Marvel", "red"))
+	require.NoError(t, err)
+	require.Equal(t, "POST /hero-m-2024.01/_update/1?retry_on_conflict=5", requests[3])
+	require.Contains(t, bodies[3], `"doc_as_upsert":true`)
+
+	// Missing document
+	found = false
+	err = store.SetFields(NewHero, "1", map[string]any{"name": "Ironman"}, "m")
+	require.True(t, errors.Is(err, es.ErrNotFound))
+	require.Len(t, requests, 5)
+}
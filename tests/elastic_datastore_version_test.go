@@ -0,0 +1,71 @@
+// Test optimistic concurrency control
+package test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	es "github.com/go-yaaf/yaaf-common-elasticsearch/elasticsearch"
+)
+
+// stubTransport is a fake HTTP transport which returns the response of the handler
+type stubTransport func(req *http.Request) (int, string)
+
+func (st stubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	status, body := st(req)
+	header := http.Header{}
+	header.Set("X-Elastic-Product", "Elasticsearch")
+	header.Set("Content-Type", "application/json")
+	return &http.Response{
+		StatusCode: status,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func TestOptimisticConcurrencyControl(t *testing.T) {
+
+	var query string
+	transport := stubTransport(func(req *http.Request) (int, string) {
+		query = req.URL.RawQuery
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/_search"):
+			return http.StatusOK, `{"took":1,"timed_out":false,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0},
+				"hits":{"total":{"value":1,"relation":"eq"},"hits":[{"_index":"hero-m-2026.10","_id":"1","_seq_no":7,"_primary_term":2,"_score":1,
+				"_source":{"id":"1","key":"m","num":1,"name":"Ironman"}}]}}`
+		case req.Method == http.MethodPut && strings.Contains(req.URL.RawQuery, "if_seq_no=7"):
+			return http.StatusOK, `{"_index":"hero-m-2026.10","_id":"1","_version":2,"result":"updated","_seq_no":8,"_primary_term":2,
+				"_shards":{"total":1,"successful":1,"failed":0}}`
+		default:
+			return http.StatusConflict, `{"error":{"root_cause":[{"type":"version_conflict_engine_exception","reason":"[1]: version conflict, required seqNo [7], primary term [2]. current document has seqNo [8] and primary term [2]","index":"hero-m-2026.10"}],
+				"type":"version_conflict_engine_exception","reason":"[1]: version conflict","index":"hero-m-2026.10"},"status":409}`
+		}
+	})
+
+	store, err := es.NewElasticStoreWithOptions(es.WithHosts("http://localhost:9200"), es.WithTransport(transport))
+	require.NoError(t, err)
+
+	entity, version, err := store.GetWithVersion(NewHero, "1", "m")
+	require.NoError(t, err)
+	require.Equal(t, "Ironman", entity.(*Hero).Name)
+	require.Equal(t, es.Version{Index: "hero-m-2026.10", SeqNo: 7, PrimaryTerm: 2}, version)
+
+	// Update with the current version
+	_, next, err := store.UpdateIfMatch(entity, version)
+	require.NoError(t, err)
+	require.Contains(t, query, "if_primary_term=2")
+	require.Equal(t, es.Version{Index: "hero-m-2026.10", SeqNo: 8, PrimaryTerm: 2}, next)
+
+	// Update and delete with stale version
+	_, _, err = store.UpdateIfMatch(entity, es.Version{Index: "hero-m-2026.10", SeqNo: 6, PrimaryTerm: 2})
+	require.True(t, errors.Is(err, es.ErrVersionConflict))
+
+	err = store.DeleteIfMatch("1", es.Version{Index: "hero-m-2026.10", SeqNo: 6, PrimaryTerm: 2})
+	require.True(t, errors.Is(err, es.ErrVersionConflict))
+}